@@ -0,0 +1,131 @@
+package pgmigrate
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+// ChecksumAlgorithm is a way of calculating the checksum of a migration's SQL.
+// The checksum is recorded in the migrations table when the migration is
+// applied, and [Migrator.Verify] compares it against the current SQL of the
+// migration to find migrations that have changed since they were applied.
+//
+// Every checksum records which algorithm produced it, so records written with
+// different algorithms can be verified side by side. MD5 checksums are stored
+// as plain hex strings, exactly as earlier versions of pgmigrate stored them;
+// the checksums of other algorithms are prefixed with the algorithm's name,
+// like `sha256:...`.
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumMD5 is the MD5 hash of the SQL, see [Migration.MD5].
+	ChecksumMD5 ChecksumAlgorithm = "md5"
+	// ChecksumSHA256 is the SHA-256 hash of the SQL.
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	// ChecksumNormalized is the SHA-256 hash of the SQL after removing
+	// comments and collapsing whitespace, so that re-indenting a migration,
+	// converting its line endings, or editing its comments does not change
+	// its checksum. The contents of quoted strings, quoted identifiers, and
+	// dollar-quoted strings (like function bodies) are hashed as-is.
+	ChecksumNormalized ChecksumAlgorithm = "normalized"
+)
+
+// Sum returns the checksum of the given SQL, formatted as it is stored in the
+// migrations table.
+func (a ChecksumAlgorithm) Sum(sql string) string {
+	switch a {
+	case ChecksumSHA256:
+		return fmt.Sprintf("%s:%x", a, sha256.Sum256([]byte(sql)))
+	case ChecksumNormalized:
+		return fmt.Sprintf("%s:%x", a, sha256.Sum256([]byte(pgtools.NormalizeSQL(sql))))
+	default:
+		return fmt.Sprintf("%x", md5.Sum([]byte(sql)))
+	}
+}
+
+// validate returns an error if the algorithm is not known.
+func (a ChecksumAlgorithm) validate() error {
+	switch a {
+	case "", ChecksumMD5, ChecksumSHA256, ChecksumNormalized:
+		return nil
+	}
+	return fmt.Errorf("unknown checksum algorithm %q", a)
+}
+
+// checksumAlgorithmOf returns the algorithm that produced a checksum stored in
+// the migrations table. Checksums without a known prefix were produced by
+// [ChecksumMD5].
+func checksumAlgorithmOf(checksum string) ChecksumAlgorithm {
+	if prefix, _, ok := strings.Cut(checksum, ":"); ok {
+		switch algorithm := ChecksumAlgorithm(prefix); algorithm {
+		case ChecksumSHA256, ChecksumNormalized:
+			return algorithm
+		}
+	}
+	return ChecksumMD5
+}
+
+// Checksum returns the checksum that is recorded in the migrations table when
+// the migration is applied: the declared checksum of a Go migration, or the
+// checksum of the SQL of any other migration, calculated with the
+// [Migrator.ChecksumAlgorithm].
+func (m *Migrator) Checksum(migration Migration) string {
+	if migration.Func != nil {
+		return migration.FuncChecksum
+	}
+	return m.ChecksumAlgorithm.Sum(migration.SQL)
+}
+
+// expectedChecksum returns the checksum that the record of an applied
+// migration should have if the migration has not changed since it was
+// applied, calculated with the same algorithm as the recorded checksum.
+func expectedChecksum(migration Migration, recorded string) string {
+	if migration.Func != nil {
+		return migration.FuncChecksum
+	}
+	return checksumAlgorithmOf(recorded).Sum(migration.SQL)
+}
+
+// upgradeChecksums rewrites the checksums of applied migrations that were
+// recorded with a different algorithm than the current ChecksumAlgorithm.
+// Only checksums that still match their migration are rewritten, so that
+// [Migrator.Verify] keeps reporting any migration that has changed.
+func (m *Migrator) upgradeChecksums(ctx context.Context, db Querier) error {
+	applied, err := m.Applied(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrationsByID := map[string]Migration{}
+	for _, migration := range m.Migrations {
+		migrationsByID[migration.ID] = migration
+	}
+	var updates []ChecksumUpdate
+	for _, record := range applied {
+		migration, ok := migrationsByID[record.ID]
+		if !ok || migration.Func != nil {
+			continue
+		}
+		if checksumAlgorithmOf(record.Checksum) == checksumAlgorithmOf(m.Checksum(migration)) {
+			continue
+		}
+		if record.Checksum != expectedChecksum(migration, record.Checksum) {
+			continue
+		}
+		m.info(ctx, "upgrading checksum",
+			LogField{Key: "migration_id", Value: record.ID},
+			LogField{Key: "from_checksum", Value: record.Checksum},
+			LogField{Key: "to_checksum", Value: m.Checksum(migration)},
+		)
+		updates = append(updates, ChecksumUpdate{MigrationID: record.ID, NewChecksum: m.Checksum(migration)})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	_, err = m.SetChecksums(ctx, db, updates...)
+	return err
+}
@@ -0,0 +1,34 @@
+package pgmigrate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/peterldowns/testy/check"
+)
+
+func TestChecksumAlgorithmSum(t *testing.T) {
+	t.Parallel()
+	migration := Migration{ID: "0001_initial", SQL: "CREATE TABLE users (\n  name text\n);\n"}
+	check.Equal(t, migration.MD5(), ChecksumMD5.Sum(migration.SQL))
+	check.True(t, strings.HasPrefix(ChecksumSHA256.Sum(migration.SQL), "sha256:"))
+	check.True(t, strings.HasPrefix(ChecksumNormalized.Sum(migration.SQL), "normalized:"))
+
+	// The normalized checksum ignores comments, indentation, and line endings.
+	edited := "-- create the users table\r\nCREATE TABLE users (\r\n    name text\r\n);\r\n"
+	check.Equal(t, ChecksumNormalized.Sum(migration.SQL), ChecksumNormalized.Sum(edited))
+	check.NotEqual(t, ChecksumSHA256.Sum(migration.SQL), ChecksumSHA256.Sum(edited))
+	check.NotEqual(t, ChecksumNormalized.Sum(migration.SQL), ChecksumNormalized.Sum("CREATE TABLE users (\n  email text\n);\n"))
+}
+
+func TestChecksumAlgorithmOf(t *testing.T) {
+	t.Parallel()
+	sql := "SELECT 1;"
+	for _, algorithm := range []ChecksumAlgorithm{ChecksumMD5, ChecksumSHA256, ChecksumNormalized} {
+		check.Equal(t, algorithm, checksumAlgorithmOf(algorithm.Sum(sql)))
+	}
+	// Declared checksums of Go migrations, and unknown prefixes, are treated
+	// like MD5 checksums: they are compared as-is.
+	check.Equal(t, ChecksumMD5, checksumAlgorithmOf("reencode-avatars-v1"))
+	check.Equal(t, ChecksumMD5, checksumAlgorithmOf("v2:reencode-avatars"))
+}
@@ -1,20 +1,35 @@
 package root
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"os"
 
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 
 	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
 )
 
+var AppliedFlags struct {
+	ShowSQL *bool
+}
+
 var appliedCmd = &cobra.Command{ //nolint:gochecknoglobals
 	Use:     "applied",
 	Aliases: []string{"list", "ls"},
 	Short:   "Show all previously-applied migrations",
 	Long: shared.CLIHelp(`
 Prints the previously-applied migrations in the order that they were applied
-(applied_at, id ASC).
+(applied_at, id ASC). Migrations whose files are marked with a
+"-- pgmigrate:no-transaction" directive are shown with "no_transaction=true".
+
+Each migration is shown with who applied it: the Postgres user, the hostname
+and application_name of the client, and the version of pgmigrate. With
+"--show-sql", the exact SQL that was applied is shown as well, or the template
+of a templated migration. These details are empty for migrations applied before
+pgmigrate started recording them.
 
 If there are no applied migrations, or the specified table does not exist, this
 command will print nothing and exit successfully.
@@ -24,35 +39,54 @@ command will print nothing and exit successfully.
 	RunE: func(cmd *cobra.Command, _ []string) error {
 		shared.State.Parse()
 		migrations := shared.State.Migrations()
-		database := shared.State.Database()
-		if err := shared.Validate(database, migrations); err != nil {
+		if err := shared.Validate(migrations); err != nil {
 			return err
 		}
 		dir := os.DirFS(migrations.Value())
 
-		slogger, mlogger := shared.State.Logger()
-		db, err := shared.OpenDB()
-		if err != nil {
-			return err
-		}
-		defer db.Close()
+		return shared.ForEachDatabase(cmd.Context(), func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger shared.LogAdapter) (string, error) {
+			m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
+			if err != nil {
+				return "", err
+			}
 
-		m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
-		if err != nil {
-			return err
-		}
-
-		applied, err := m.Applied(cmd.Context(), db)
-		if err != nil {
-			return err
-		}
-		for _, m := range applied {
-			slogger.With(
-				"applied_at", m.AppliedAt,
-				"checksum", m.Checksum,
-				"execution_time_ms", m.ExecutionTimeInMillis,
-			).Info(m.ID)
-		}
-		return nil
+			applied, err := m.Applied(ctx, db)
+			if err != nil {
+				return "", err
+			}
+			noTransaction := map[string]bool{}
+			for _, migration := range m.Migrations {
+				noTransaction[migration.ID] = migration.NoTransaction
+			}
+			for _, m := range applied {
+				attrs := []any{
+					"applied_at", m.AppliedAt,
+					"checksum", m.Checksum,
+					"execution_time_ms", m.ExecutionTimeInMillis,
+				}
+				if noTransaction[m.ID] {
+					attrs = append(attrs, "no_transaction", true)
+				}
+				for _, attr := range []struct{ key, value string }{
+					{"applied_by", m.AppliedBy},
+					{"hostname", m.Hostname},
+					{"application_name", m.ApplicationName},
+					{"pgmigrate_version", m.PgmigrateVersion},
+				} {
+					if attr.value != "" {
+						attrs = append(attrs, attr.key, attr.value)
+					}
+				}
+				if *AppliedFlags.ShowSQL && m.AppliedSQL != "" {
+					attrs = append(attrs, "sql", m.AppliedSQL)
+				}
+				slogger.With(attrs...).Info(m.ID)
+			}
+			return fmt.Sprintf("%d applied", len(applied)), nil
+		})
 	},
 }
+
+func init() {
+	AppliedFlags.ShowSQL = appliedCmd.Flags().Bool("show-sql", false, "also show the SQL that was applied for each migration")
+}
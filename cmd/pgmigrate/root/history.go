@@ -0,0 +1,154 @@
+package root
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var HistoryFlags struct {
+	IDs     *[]string
+	Since   *string
+	Until   *string
+	Action  *string
+	Outcome *string
+	Limit   *int
+}
+
+var historyCmd = &cobra.Command{ //nolint:gochecknoglobals
+	Use:   "history",
+	Short: "Show the history of migration attempts and ops changes",
+	Long: shared.CLIHelp(`
+Prints the entries in the history table in the order that they were recorded.
+The history table is only written to when it is configured with
+"--history-table", $PGM_HISTORYTABLE, or "history_table" in the config file;
+this command reads from "public.pgmigrate_history" if it isn't.
+
+Every attempt to apply a migration is recorded with action=apply and an
+outcome: "succeeded", "failed" along with the SQLSTATE and error message, or
+"rolled-back" if it was applied with "--atomic" but another migration in the
+same transaction failed. Every change made by the "ops" commands is recorded
+with the action "mark-applied", "mark-unapplied", or "set-checksum", and the
+checksum before and after the change. Each entry also records who made the
+change: the Postgres user, the hostname and application_name of the client,
+and the version of pgmigrate.
+
+The entries can be filtered with "--id", which can be passed multiple times,
+"--since" and "--until", which accept a timestamp like "2024-01-02T15:04:05Z"
+or a duration like "24h" to mean that long ago, "--action", and "--outcome".
+With "--limit N", only the most recent N matching entries are shown.
+
+If the history table does not exist, this command will print nothing and exit
+successfully.
+	`),
+	GroupID:          "migrating",
+	TraverseChildren: true,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		shared.State.Parse()
+		filter := pgmigrate.HistoryFilter{
+			MigrationIDs: *HistoryFlags.IDs,
+			Action:       pgmigrate.HistoryAction(*HistoryFlags.Action),
+			Outcome:      pgmigrate.HistoryOutcome(*HistoryFlags.Outcome),
+			Limit:        *HistoryFlags.Limit,
+		}
+		var err error
+		if filter.Since, err = parseHistoryTime("since", *HistoryFlags.Since); err != nil {
+			return err
+		}
+		if filter.Until, err = parseHistoryTime("until", *HistoryFlags.Until); err != nil {
+			return err
+		}
+
+		return shared.ForEachDatabase(cmd.Context(), func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger shared.LogAdapter) (string, error) {
+			m := pgmigrate.NewMigrator(nil)
+			m.Logger = mlogger
+			m.TableName = shared.State.TableName().Value()
+			m.HistoryTableName = shared.State.HistoryTable().Value()
+			if m.HistoryTableName == "" {
+				m.HistoryTableName = pgmigrate.DefaultHistoryTableName
+			}
+
+			entries, err := m.History(ctx, db, filter)
+			if err != nil {
+				return "", err
+			}
+			for _, entry := range entries {
+				attrs := []any{
+					"action", entry.Action,
+					"outcome", entry.Outcome,
+					"started_at", entry.StartedAt,
+				}
+				if entry.Action == pgmigrate.HistoryApply {
+					attrs = append(attrs, "execution_time_ms", entry.ExecutionTimeInMillis)
+				}
+				for _, attr := range []struct{ key, value string }{
+					{"error_code", entry.ErrorCode},
+					{"error", entry.ErrorMessage},
+					{"checksum_before", entry.ChecksumBefore},
+					{"checksum_after", entry.ChecksumAfter},
+					{"actor", entry.Actor},
+					{"hostname", entry.Hostname},
+					{"application_name", entry.ApplicationName},
+					{"pgmigrate_version", entry.PgmigrateVersion},
+				} {
+					if attr.value != "" {
+						attrs = append(attrs, attr.key, attr.value)
+					}
+				}
+				if entry.Outcome == pgmigrate.HistoryFailed {
+					slogger.With(attrs...).Error(entry.MigrationID)
+				} else {
+					slogger.With(attrs...).Info(entry.MigrationID)
+				}
+			}
+			return fmt.Sprintf("%d entries", len(entries)), nil
+		})
+	},
+}
+
+// parseHistoryTime parses the value of "--since" or "--until", which is either
+// an RFC3339 timestamp or a duration before now. It returns the zero time if
+// the value is empty.
+func parseHistoryTime(flag, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	ago, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`invalid --%s "%s", must be a timestamp like "2024-01-02T15:04:05Z" or a duration like "24h"`, flag, value)
+	}
+	return time.Now().Add(-ago), nil
+}
+
+func init() {
+	HistoryFlags.IDs = historyCmd.Flags().StringArray("id", nil, "only show entries about the migration with this ID, can be passed multiple times")
+	HistoryFlags.Since = historyCmd.Flags().String("since", "", "only show entries that started at or after this timestamp, or this long ago")
+	HistoryFlags.Until = historyCmd.Flags().String("until", "", "only show entries that started before this timestamp, or this long ago")
+	HistoryFlags.Action = historyCmd.Flags().String(
+		"action",
+		"",
+		fmt.Sprintf(
+			"only show entries with this action: '%s', '%s', '%s', or '%s'",
+			pgmigrate.HistoryApply, pgmigrate.HistoryMarkApplied, pgmigrate.HistoryMarkUnapplied, pgmigrate.HistorySetChecksum,
+		),
+	)
+	HistoryFlags.Outcome = historyCmd.Flags().String(
+		"outcome",
+		"",
+		fmt.Sprintf(
+			"only show entries with this outcome: '%s', '%s', or '%s'",
+			pgmigrate.HistorySucceeded, pgmigrate.HistoryFailed, pgmigrate.HistoryRolledBack,
+		),
+	)
+	HistoryFlags.Limit = historyCmd.Flags().Int("limit", 0, "only show the most recent N matching entries")
+}
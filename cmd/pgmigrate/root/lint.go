@@ -0,0 +1,130 @@
+package root
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var lintCmd = &cobra.Command{ //nolint:gochecknoglobals
+	Use:   "lint",
+	Short: "Check the migrations directory for conflicting migration files",
+	Long: shared.CLIHelp(`
+Checks the migrations directory, without connecting to a database, for:
+- migration files with the same ID, like "a/00140_users.sql" and
+"b/00140_users.sql", which pgmigrate cannot load
+- migration files with the same numeric prefix but different names, like
+"00140_add_users.sql" and "00140_add_posts.sql", which usually means that two
+branches both added a migration with "pgmigrate new" and were merged
+
+Migrations with the same prefix are still applied in order of their full IDs,
+but that order may not be the one that their authors expected. Rename one of
+them to use the next number in the sequence.
+
+If there are any errors or warnings, exits with status code 1.
+Otherwise, succeeds without printing anything and exits with status code 0.
+	`),
+	Example: shared.CLIExample(`
+# Check the migrations directory before merging a branch
+pgmigrate lint --migrations ./migrations
+	`),
+	GroupID:          "dev",
+	TraverseChildren: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		shared.State.Parse()
+		migrationsDir := shared.State.Migrations()
+		if err := shared.Validate(migrationsDir); err != nil {
+			return err
+		}
+		slogger, _ := shared.State.Logger()
+		dir := os.DirFS(migrationsDir.Value())
+
+		issues := 0
+		_, err := pgmigrate.Load(dir)
+		var derr *pgmigrate.DuplicateIDError
+		if errors.As(err, &derr) {
+			for _, id := range sortedKeys(derr.Paths) {
+				issues++
+				slogger.Error("duplicate migration ID", "id", id, "paths", strings.Join(derr.Paths[id], ", "))
+			}
+		} else if err != nil {
+			return err
+		}
+
+		prefixes, err := idsByPrefix(dir)
+		if err != nil {
+			return err
+		}
+		for _, prefix := range sortedKeys(prefixes) {
+			if ids := prefixes[prefix]; len(ids) > 1 {
+				issues++
+				slogger.Warn("duplicate migration prefix", "number", prefix, "ids", strings.Join(ids, ", "))
+			}
+		}
+		if issues != 0 {
+			slogger.Error(errLintFailed.Error(), "count", issues)
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// errLintFailed means that there were lint errors or warnings, which have
+// already been printed.
+var errLintFailed = errors.New("found lint issues")
+
+// idsByPrefix returns the distinct IDs of the migration files in dir, grouped
+// by their numeric prefix: the digits before the first "_" in the ID, like
+// "00140" in "00140_add_users". IDs without a numeric prefix are skipped.
+func idsByPrefix(dir fs.FS) (map[string][]string, error) {
+	prefixes := map[string][]string{}
+	seen := map[string]bool{}
+	err := fs.WalkDir(dir, ".", func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(path, ".sql") {
+			return nil
+		}
+		id := pgmigrate.IDFromFilename(path)
+		prefix, _, found := strings.Cut(id, "_")
+		if !found || !isDigits(prefix) || seen[id] {
+			return nil
+		}
+		seen[id] = true
+		prefixes[prefix] = append(prefixes[prefix], id)
+		return nil
+	})
+	for _, ids := range prefixes {
+		sort.Strings(ids)
+	}
+	return prefixes, err
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
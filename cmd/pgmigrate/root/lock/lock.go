@@ -0,0 +1,81 @@
+package lock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var Command = &cobra.Command{ //nolint:gochecknoglobals
+	Use:   "lock",
+	Short: "Inspect and release the advisory lock held while migrating",
+	Long: shared.CLIHelp(`
+While "pgmigrate migrate" applies migrations, it holds a Postgres advisory lock
+so that only one migrator can apply migrations to a database at a time. Each
+migrations table has its own lock. Other migrators wait until the lock is
+released, and log the details of the session that holds it while they wait.
+	`),
+	GroupID: "ops",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return fmt.Errorf(`invalid command: "%s"`, args[0])
+		}
+		return cmd.Help()
+	},
+}
+
+func init() {
+	Command.AddCommand(status)
+	Command.AddCommand(release)
+}
+
+// newMigrator returns a migrator that uses the lock for the configured
+// migrations table. Migrations are not needed to inspect the lock.
+func newMigrator(logger pgmigrate.Logger) (*pgmigrate.Migrator, error) {
+	m := pgmigrate.NewMigrator(nil)
+	m.Logger = logger
+	m.TableName = shared.State.TableName().Value()
+	m.LockNamespace = shared.State.LockNamespace().Value()
+	locker, err := shared.State.Locker()
+	if err != nil {
+		return nil, err
+	}
+	m.Locker = locker
+	return m, nil
+}
+
+func logHolder(slogger *log.Logger, msg string, holder pgmigrate.LockHolder) {
+	var attrs []any
+	if holder.PID != 0 {
+		attrs = append(attrs, "pid", holder.PID)
+	}
+	for _, attr := range []struct{ key, value string }{
+		{"username", holder.Username},
+		{"application_name", holder.ApplicationName},
+		{"client_addr", holder.ClientAddr},
+		{"state", holder.State},
+		{"owner", holder.Owner},
+	} {
+		if attr.value != "" {
+			attrs = append(attrs, attr.key, attr.value)
+		}
+	}
+	for _, attr := range []struct {
+		key   string
+		value time.Time
+	}{
+		{"query_start", holder.QueryStart},
+		{"acquired_at", holder.AcquiredAt},
+		{"expires_at", holder.ExpiresAt},
+	} {
+		if !attr.value.IsZero() {
+			attrs = append(attrs, attr.key, attr.value.Format(time.RFC3339))
+		}
+	}
+	slogger.With(attrs...).Info(msg)
+}
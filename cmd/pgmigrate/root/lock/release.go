@@ -0,0 +1,112 @@
+package lock
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var ReleaseFlags struct {
+	Terminate *bool
+	Yes       *bool
+}
+
+var release = &cobra.Command{
+	Use:   "release",
+	Short: "release the migrations lock by terminating the session that holds it",
+	Long: shared.CLIHelp(`
+An advisory lock can only be released by the session that holds it, so the only
+way to release a lock held by a stuck migrator is to end its session with
+pg_terminate_backend. This requires "--terminate", and asks for confirmation
+before terminating each session unless "--yes" is passed.
+
+Terminating a migrator rolls back the migration that it was applying, unless
+that migration has the "-- pgmigrate:no-transaction" directive, in which case
+it may be left partially applied.
+
+With "--lock-strategy lease", there is no session to terminate. A lease is
+released by its holder when it finishes, and expires on its own if its holder
+crashes.
+	`),
+	Example: shared.CLIExample(`
+# Terminate the session holding the lock, after confirming
+pgmigrate lock release --terminate
+
+# Terminate the session holding the lock without confirming
+pgmigrate lock release --terminate --yes
+	`),
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		ctx := cmd.Context()
+		shared.State.Parse()
+		database := shared.State.Database()
+		if err := shared.Validate(database); err != nil {
+			return err
+		}
+		db, err := shared.OpenDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		slogger, mlogger := shared.State.Logger()
+		m, err := newMigrator(mlogger)
+		if err != nil {
+			return err
+		}
+
+		// Execution
+		holders, err := m.LockHolders(ctx, db)
+		if err != nil {
+			return err
+		}
+		if len(holders) == 0 {
+			slogger.Info("lock is not held")
+			return nil
+		}
+		for _, holder := range holders {
+			logHolder(slogger, "lock is held", holder)
+		}
+		for _, holder := range holders {
+			if holder.PID == 0 {
+				return fmt.Errorf("a lease cannot be terminated, it expires at %s unless %s renews it", holder.ExpiresAt.Format(time.RFC3339), holder.Owner)
+			}
+		}
+		if !*ReleaseFlags.Terminate {
+			return fmt.Errorf(`the lock can only be released by terminating the session that holds it, pass "--terminate" to do so`)
+		}
+		stdin := bufio.NewReader(os.Stdin)
+		for _, holder := range holders {
+			if !*ReleaseFlags.Yes {
+				fmt.Fprintf(os.Stderr, "Terminate the session with pid %d? [y/N] ", holder.PID)
+				answer, err := stdin.ReadString('\n')
+				if err != nil && answer == "" {
+					return fmt.Errorf("read confirmation: %w", err)
+				}
+				if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+					slogger.Info("not terminating", "pid", holder.PID)
+					continue
+				}
+			}
+			terminated, err := m.TerminateLockHolder(ctx, db, holder.PID)
+			if err != nil {
+				return err
+			}
+			if terminated {
+				slogger.Info("terminated", "pid", holder.PID)
+			} else {
+				slogger.Info("no longer holds the lock", "pid", holder.PID)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	ReleaseFlags.Terminate = release.Flags().Bool("terminate", false, "terminate the session that holds the lock")
+	ReleaseFlags.Yes = release.Flags().BoolP("yes", "y", false, "do not ask for confirmation before terminating")
+}
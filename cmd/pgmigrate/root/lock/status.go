@@ -0,0 +1,60 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var status = &cobra.Command{
+	Use:     "status",
+	Aliases: []string{"show", "holder"},
+	Short:   "show which session holds the migrations lock, if any",
+	Long: shared.CLIHelp(`
+Shows the session that holds the migrations lock: its backend PID, Postgres
+user, application_name, client address, the start time of its current or most
+recent query, and its state. Postgres only shows some of these details for
+other users' sessions to superusers and members of pg_read_all_stats.
+
+With "--lock-strategy lease", shows the owner of the lease instead, along with
+when it was acquired and when it expires unless it is renewed.
+
+If the lock is not held, this command says so and exits successfully.
+	`),
+	Example: shared.CLIExample(`
+# Show who holds the lock for the default migrations table
+pgmigrate lock status
+
+# Show who holds the lock for a different migrations table
+pgmigrate lock status --table-name myschema.migrations
+	`),
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		shared.State.Parse()
+		return shared.ForEachDatabase(cmd.Context(), func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger shared.LogAdapter) (string, error) {
+			m, err := newMigrator(mlogger)
+			if err != nil {
+				return "", err
+			}
+			holders, err := m.LockHolders(ctx, db)
+			if err != nil {
+				return "", err
+			}
+			if len(holders) == 0 {
+				slogger.Info("lock is not held")
+				return "not held", nil
+			}
+			for _, holder := range holders {
+				logHolder(slogger, "lock is held", holder)
+			}
+			if holders[0].PID == 0 {
+				return fmt.Sprintf("held by %s", holders[0].Owner), nil
+			}
+			return fmt.Sprintf("held by pid %d", holders[0].PID), nil
+		})
+	},
+}
@@ -1,13 +1,36 @@
 package root
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 
+	"github.com/peterldowns/pgmigrate"
 	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
 )
 
+var MigrateFlags struct {
+	DryRun               *bool
+	Atomic               *bool
+	To                   *string
+	LockTimeout          *time.Duration
+	StatementTimeout     *time.Duration
+	LockRetryMaxAttempts *int
+	LockRetryBackoff     *time.Duration
+	LockMaxWait          *time.Duration
+	LockNoWait           *bool
+	LockSpinInterval     *time.Duration
+	Tenants              *bool
+	TenantSchemas        *[]string
+	TenantsQuery         *string
+	TenantConcurrency    *int
+}
+
 var migrateCmd = &cobra.Command{ //nolint:gochecknoglobals
 	Use:     "migrate",
 	Aliases: []string{"apply"},
@@ -20,12 +43,34 @@ migrations table, with the following schema:
   - checksum: text not null
   - execution_time_in_millis: integer not null
   - applied_at: timestamp with time zone not null
+  - applied_by: text, the Postgres user (current_user) that applied it
+  - hostname: text, the hostname of the machine that applied it
+  - application_name: text, the application_name of the connection
+  - pgmigrate_version: text, the version of pgmigrate that applied it
+  - applied_sql: text, the SQL that was applied, or the template of a
+    "-- pgmigrate:template" migration, so that "--var" values are never stored
+
+An existing migrations table without the newer columns is upgraded in place
+while the advisory lock is held.
 
 First, it acquires an advisory lock to prevent conflicts with other instances
 that may be running in parallel. This way only one migrator will attempt to run
 the migrations at any point in time. This makes it safe to use "migrate" on
 app/container startup even if you run multiple copies of the application.
 
+If another migrator holds the advisory lock, migrate waits for it to be
+released, logging the details of the session that holds it every 10 seconds
+(see "pgmigrate lock status"). With "--lock-max-wait", it gives up after
+waiting that long, and with "--lock-no-wait" it gives up immediately. The lock
+is named after the migrations table and "--lock-namespace", so every migrator
+of the same table must use the same namespace.
+
+Advisory locks belong to a database session, so they don't work through a
+connection pooler like PgBouncer in transaction pooling mode. With
+"--lock-strategy lease", migrate instead holds a lease: a row in the table
+given by "--lock-lease-table" that it renews while it applies migrations. If
+a migrator crashes, its lease expires after "--lock-lease-ttl".
+
 Second, calculate a plan of migrations to apply. The plan will be a list of
 migrations that have not yet been marked as applied in the migrations table.
 The migrations in the plan will be ordered by their IDs, in ascending
@@ -43,6 +88,25 @@ migration results in NO record for that migration in the migrations table,
 which means that future attempts to run the migrations will include it in
 their plan.
 
+Migrations whose files start with a "-- pgmigrate:no-transaction" comment are
+the exception. Each of their statements is run outside of a transaction, which
+is necessary for statements like "CREATE INDEX CONCURRENTLY", and the record is
+only created once all of them have succeeded. If one of these migrations fails
+partway through, the statements that already succeeded are NOT rolled back, so
+write them to be safe to re-run.
+
+With "--atomic", the entire plan is instead applied inside of a single
+transaction, including the records in the migrations table, so that a failed
+migration rolls back every migration before it as well. Migrate refuses to
+start in this mode if any migration in the plan cannot run in a transaction.
+
+You can set the "lock_timeout" and "statement_timeout" Postgres settings for
+each migration with "--lock-timeout" and "--statement-timeout", or for a single
+migration with header comments like "-- pgmigrate:lock_timeout 5s". If a
+migration fails because it could not acquire a lock in time, it will be retried
+up to "--lock-retry-max-attempts" times, waiting "--lock-retry-backoff" before
+the first retry and twice as long before each retry after that.
+
 Migrate will immediately return the error related to a failed migration,
 and will NOT attempt to run any further migrations. Any migrations applied
 before the failure will remain applied. Any migrations not yet applied will
@@ -53,42 +117,184 @@ Fourth, if all the migrations in the plan are applied successfully, it calls
 applied in the migrations table.
 
 Finally, the advisory lock is released. 
+
+With "--history-table", every attempt to apply a migration is also recorded in
+the history table, outside of the migration's transaction, so that failures are
+recorded along with their error. See "pgmigrate history".
+
+With "--to <id>", only the migrations in the plan whose IDs are less than or
+equal to the given ID are applied, which is useful for bringing a database to
+the exact state before or after a specific migration. The ID must be the ID of
+a known migration.
+
+With "--dry-run", the migrations in the plan are instead all applied inside of
+a single transaction that is always rolled back, and the outcome and timing of
+each one is shown. The first failure stops the dry run, and the migrations
+after it are shown as skipped. Migrations with the "-- pgmigrate:no-transaction"
+directive cannot run inside of a transaction, so they are always skipped.
+A dry run only checks the default schema, so it cannot be used with
+"--tenants".
+
+With "--tenants", the migrations are instead applied to each tenant's schema,
+for databases that keep one schema per customer. The tenants are the schemas
+given with "--tenant-schema", plus the schemas returned by the query given with
+"--tenants-query", which must return a single column of schema names. Each
+tenant gets its own migrations table, with the same name but in its schema, and
+its own advisory lock, and its migrations run with the "search_path" set to
+its schema. Up to "--tenant-concurrency" tenants are migrated at the same time.
+A failed tenant does not stop the others, and a result is shown for each one.
 	`),
 	GroupID:          "migrating",
 	TraverseChildren: true,
 	RunE: func(cmd *cobra.Command, _ []string) error {
 		shared.State.Parse()
-		database := shared.State.Database()
 		migrations := shared.State.Migrations()
-		if err := shared.Validate(database, migrations); err != nil {
+		if err := shared.Validate(migrations); err != nil {
 			return err
 		}
-
-		slogger, mlogger := shared.State.Logger()
 		dir := os.DirFS(migrations.Value())
-
-		db, err := shared.OpenDB()
-		if err != nil {
-			return err
+		if *MigrateFlags.To != "" && *MigrateFlags.DryRun {
+			return fmt.Errorf("--to cannot be used with --dry-run")
 		}
-		defer db.Close()
-
-		m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
-		if err != nil {
-			return err
+		if *MigrateFlags.To != "" && *MigrateFlags.Tenants {
+			return fmt.Errorf("--to cannot be used with --tenants")
 		}
-
-		verrs, err := m.Migrate(cmd.Context(), db)
-		if err != nil {
+		if *MigrateFlags.DryRun && *MigrateFlags.Tenants {
+			return fmt.Errorf("--dry-run cannot be used with --tenants")
+		}
+		if err := validateMigrateTimeouts(); err != nil {
 			return err
 		}
-		for _, verr := range verrs {
-			var attrs []any
-			for key, val := range verr.Fields {
-				attrs = append(attrs, key, val)
+
+		return shared.ForEachDatabase(cmd.Context(), func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger shared.LogAdapter) (string, error) {
+			m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
+			if err != nil {
+				return "", err
 			}
-			slogger.With(attrs...).Warn(verr.Message)
-		}
-		return nil
+			configureMigrate(m)
+
+			if *MigrateFlags.DryRun {
+				results, err := m.DryRun(ctx, db)
+				for _, result := range results {
+					logger := slogger.With("status", result.Status)
+					switch result.Status {
+					case pgmigrate.DryRunSucceeded:
+						logger.With("execution_time_ms", result.ExecutionTimeInMillis).Info(result.ID)
+					case pgmigrate.DryRunFailed:
+						logger.With("execution_time_ms", result.ExecutionTimeInMillis, "error", result.Error).Error(result.ID)
+					case pgmigrate.DryRunSkipped:
+						logger.With("reason", result.SkipReason).Warn(result.ID)
+					}
+				}
+				return fmt.Sprintf("dry run of %d", len(results)), err
+			}
+
+			if *MigrateFlags.Tenants {
+				results, err := m.MigrateTenants(ctx, db)
+				for _, result := range results {
+					logger := slogger.With("tenant", result.Schema, "applied", len(result.Applied))
+					if result.Error != nil {
+						logger.With("error", result.Error).Error("tenant failed")
+					} else {
+						logger.Info("tenant migrated")
+					}
+					for _, verr := range result.VerificationErrors {
+						attrs := []any{"tenant", result.Schema, "kind", verr.Kind}
+						for key, val := range verr.Fields {
+							attrs = append(attrs, key, val)
+						}
+						slogger.With(attrs...).Warn(verr.Message)
+					}
+				}
+				return fmt.Sprintf("%d tenants", len(results)), err
+			}
+
+			var count int
+			m.Hooks.AfterMigrate = func(_ context.Context, applied []pgmigrate.AppliedMigration, _ error) error {
+				count = len(applied)
+				return nil
+			}
+			var verrs []pgmigrate.VerificationError
+			if *MigrateFlags.To != "" {
+				verrs, err = m.MigrateTo(ctx, db, *MigrateFlags.To)
+			} else {
+				verrs, err = m.Migrate(ctx, db)
+			}
+			if err != nil {
+				return "", err
+			}
+			for _, verr := range verrs {
+				attrs := []any{"kind", verr.Kind}
+				for key, val := range verr.Fields {
+					attrs = append(attrs, key, val)
+				}
+				slogger.With(attrs...).Warn(verr.Message)
+			}
+			details := fmt.Sprintf("applied %d", count)
+			if len(verrs) != 0 {
+				details += fmt.Sprintf(", %d warnings", len(verrs))
+			}
+			return details, nil
+		})
 	},
 }
+
+func init() {
+	MigrateFlags.DryRun = migrateCmd.Flags().Bool("dry-run", false, "apply the migrations inside of a transaction that is always rolled back")
+	MigrateFlags.To = migrateCmd.Flags().String("to", "", "only apply the migrations up to and including the migration with this ID")
+	MigrateFlags.Atomic = migrateCmd.Flags().Bool("atomic", false, "apply all of the migrations in a single transaction, so any failure rolls back all of them")
+	MigrateFlags.Tenants = migrateCmd.Flags().Bool("tenants", false, "apply the migrations to each tenant's schema")
+	MigrateFlags.TenantSchemas = migrateCmd.Flags().StringArray("tenant-schema", nil, "the schema of a tenant to migrate with --tenants, can be passed multiple times")
+	MigrateFlags.TenantsQuery = migrateCmd.Flags().String("tenants-query", "", "a query that returns the schemas of the tenants to migrate with --tenants")
+	MigrateFlags.TenantConcurrency = migrateCmd.Flags().Int("tenant-concurrency", 0, "the maximum number of tenants to migrate at the same time (default 1)")
+	MigrateFlags.LockTimeout = migrateCmd.Flags().Duration("lock-timeout", 0, "the lock_timeout to use for each migration, like '5s' (default unset)")
+	MigrateFlags.StatementTimeout = migrateCmd.Flags().Duration("statement-timeout", 0, "the statement_timeout to use for each migration, like '1m' (default unset)")
+	MigrateFlags.LockRetryMaxAttempts = migrateCmd.Flags().Int("lock-retry-max-attempts", 0, "the maximum number of attempts for a migration that times out waiting for a lock (default 1)")
+	MigrateFlags.LockRetryBackoff = migrateCmd.Flags().Duration("lock-retry-backoff", 0, fmt.Sprintf("how long to wait before retrying a migration that timed out waiting for a lock (default %s)", pgmigrate.DefaultLockRetryBackoff))
+	MigrateFlags.LockMaxWait = migrateCmd.Flags().Duration("lock-max-wait", 0, "the longest to wait for another migrator to release the advisory lock before failing, like '5m' (default forever)")
+	MigrateFlags.LockNoWait = migrateCmd.Flags().Bool("lock-no-wait", false, "fail immediately if another migrator holds the advisory lock")
+	MigrateFlags.LockSpinInterval = migrateCmd.Flags().Duration("lock-spin-interval", 0, fmt.Sprintf("how long to wait between attempts to acquire the advisory lock (default %s)", pgmigrate.DefaultLockSpinInterval))
+}
+
+// validateMigrateTimeouts returns an error if the "migrate" timeouts from the
+// config file or flags are shorter than Postgres can represent. Postgres
+// timeouts have millisecond precision, and 0ms means that there is no timeout
+// at all.
+func validateMigrateTimeouts() error {
+	config := shared.State.Config.Migrate
+	for _, timeout := range []shared.Variable[time.Duration]{
+		shared.NewVariable("lock-timeout", *MigrateFlags.LockTimeout, config.LockTimeout),
+		shared.NewVariable("statement-timeout", *MigrateFlags.StatementTimeout, config.StatementTimeout),
+	} {
+		if value := timeout.Value(); value > 0 && value < time.Millisecond {
+			return fmt.Errorf("--%s must be at least 1ms, got %s", timeout.Name(), value)
+		}
+	}
+	return nil
+}
+
+// configureMigrate applies the options from the "migrate" section of the
+// config file and any "migrate" command flags to the migrator. Flags take
+// precedence over the config file.
+func configureMigrate(m *pgmigrate.Migrator) {
+	config := shared.State.Config.Migrate
+	m.LockTimeout = shared.NewVariable("lock-timeout", *MigrateFlags.LockTimeout, config.LockTimeout).Value()
+	m.StatementTimeout = shared.NewVariable("statement-timeout", *MigrateFlags.StatementTimeout, config.StatementTimeout).Value()
+	if attempts := shared.NewVariable("lock-retry-max-attempts", *MigrateFlags.LockRetryMaxAttempts, config.LockRetryMaxAttempts); attempts.IsSet() {
+		m.LockRetryMaxAttempts = attempts.Value()
+	}
+	if backoff := shared.NewVariable("lock-retry-backoff", *MigrateFlags.LockRetryBackoff, config.LockRetryBackoff); backoff.IsSet() {
+		m.LockRetryBackoff = backoff.Value()
+	}
+	m.LockMaxWait = shared.NewVariable("lock-max-wait", *MigrateFlags.LockMaxWait, config.LockMaxWait).Value()
+	m.LockNoWait = shared.NewVariable("lock-no-wait", *MigrateFlags.LockNoWait, config.LockNoWait).Value()
+	if interval := shared.NewVariable("lock-spin-interval", *MigrateFlags.LockSpinInterval, config.LockSpinInterval); interval.IsSet() {
+		m.LockSpinInterval = interval.Value()
+	}
+	m.SingleTransaction = shared.NewVariable("atomic", *MigrateFlags.Atomic, config.Atomic).Value()
+	m.Tenants = append(append([]string(nil), config.Tenants.Schemas...), *MigrateFlags.TenantSchemas...)
+	m.TenantsQuery = shared.NewVariable("tenants-query", *MigrateFlags.TenantsQuery, config.Tenants.Query).Value()
+	if concurrency := shared.NewVariable("tenant-concurrency", *MigrateFlags.TenantConcurrency, config.Tenants.Concurrency); concurrency.IsSet() {
+		m.TenantConcurrency = concurrency.Value()
+	}
+}
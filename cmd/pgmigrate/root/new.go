@@ -148,5 +148,17 @@ func newMigrator(dir fs.FS, tableName string, logger pgmigrate.Logger) (*pgmigra
 	m := pgmigrate.NewMigrator(migrations)
 	m.Logger = logger
 	m.TableName = tableName
+	m.LockNamespace = shared.State.LockNamespace().Value()
+	m.Locker, err = shared.State.Locker()
+	if err != nil {
+		return nil, err
+	}
+	m.StrictOrdering = shared.State.StrictOrdering().Value()
+	m.ChecksumAlgorithm = shared.State.ChecksumAlgorithm().Value()
+	m.HistoryTableName = shared.State.HistoryTable().Value()
+	m.Vars, err = shared.State.Vars()
+	if err != nil {
+		return nil, err
+	}
 	return m, nil
 }
@@ -0,0 +1,76 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var DiffFlags struct {
+	ID *string
+}
+
+var diff = &cobra.Command{
+	Use:   "diff",
+	Short: "show how a migration file has changed since it was applied",
+	Long: shared.CLIHelp(`
+Prints a unified diff between the SQL that was recorded when a migration was
+applied and the current contents of its file. Nothing is printed if they are
+identical.
+
+This is useful for understanding why 'pgmigrate verify' reports a checksum
+mismatch. Go migrations, migrations marked as applied with 'pgmigrate ops
+mark-applied', and migrations applied by older versions of pgmigrate have no
+recorded SQL, so they cannot be diffed.
+	`),
+	Example: shared.CLIExample(`
+# Show how 123_example.sql has changed since it was applied
+pgmigrate ops diff 123_example
+pgmigrate ops diff --id 123_example
+	`),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		// Argument parsing
+		if len(args) == 1 {
+			*DiffFlags.ID = args[0]
+		} else if len(args) != 0 {
+			return fmt.Errorf("unexpected arguments: ['%s']", strings.Join(args, "', '"))
+		}
+		if *DiffFlags.ID == "" {
+			return fmt.Errorf(`required flag "--id" not set`)
+		}
+		shared.State.Parse()
+		migrationsDir := shared.State.Migrations()
+		database := shared.State.Database()
+		if err := shared.Validate(database, migrationsDir); err != nil {
+			return err
+		}
+		db, err := shared.OpenDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		dir := os.DirFS(migrationsDir.Value())
+		_, mlogger := shared.State.Logger()
+		m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
+		if err != nil {
+			return err
+		}
+
+		// Execution
+		result, err := m.Diff(ctx, db, *DiffFlags.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Print(result)
+		return nil
+	},
+}
+
+func init() {
+	DiffFlags.ID = diff.Flags().StringP("id", "i", "", "migration id to diff")
+}
@@ -5,6 +5,7 @@ import (
 	"io/fs"
 
 	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
 )
 
 // newMigrator loads migrations from dir and returns a configured migrator for
@@ -17,5 +18,16 @@ func newMigrator(dir fs.FS, tableName string, logger pgmigrate.Logger) (*pgmigra
 	m := pgmigrate.NewMigrator(migrations)
 	m.Logger = logger
 	m.TableName = tableName
+	m.LockNamespace = shared.State.LockNamespace().Value()
+	m.Locker, err = shared.State.Locker()
+	if err != nil {
+		return nil, err
+	}
+	m.ChecksumAlgorithm = shared.State.ChecksumAlgorithm().Value()
+	m.HistoryTableName = shared.State.HistoryTable().Value()
+	m.Vars, err = shared.State.Vars()
+	if err != nil {
+		return nil, err
+	}
 	return m, nil
 }
@@ -24,4 +24,6 @@ func init() {
 	Command.AddCommand(recalculateChecksum)
 	Command.AddCommand(markUnapplied)
 	Command.AddCommand(markApplied)
+	Command.AddCommand(show)
+	Command.AddCommand(diff)
 }
@@ -0,0 +1,76 @@
+package ops
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
+)
+
+var ShowFlags struct {
+	ID *string
+}
+
+var show = &cobra.Command{
+	Use:     "show",
+	Aliases: []string{"cat"},
+	Short:   "print the SQL that was recorded when a migration was applied",
+	Example: shared.CLIExample(`
+# Print the SQL that was applied for 123_example.sql
+pgmigrate ops show 123_example
+pgmigrate ops show --id 123_example
+	`),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		// Argument parsing
+		if len(args) == 1 {
+			*ShowFlags.ID = args[0]
+		} else if len(args) != 0 {
+			return fmt.Errorf("unexpected arguments: ['%s']", strings.Join(args, "', '"))
+		}
+		if *ShowFlags.ID == "" {
+			return fmt.Errorf(`required flag "--id" not set`)
+		}
+		shared.State.Parse()
+		database := shared.State.Database()
+		if err := shared.Validate(database); err != nil {
+			return err
+		}
+		db, err := shared.OpenDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		// The recorded SQL comes from the database, so the migrations
+		// directory is not loaded; this keeps working when it has a broken
+		// or missing file, which is usually why the SQL is being looked at.
+		_, mlogger := shared.State.Logger()
+		m := pgmigrate.NewMigrator(nil)
+		m.Logger = mlogger
+		m.TableName = shared.State.TableName().Value()
+
+		// Execution
+		applied, err := m.Applied(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			if migration.ID != *ShowFlags.ID {
+				continue
+			}
+			if migration.AppliedSQL == "" {
+				return fmt.Errorf("no SQL was recorded when %s was applied", migration.ID)
+			}
+			fmt.Print(migration.AppliedSQL)
+			return nil
+		}
+		return fmt.Errorf("%s has not been applied", *ShowFlags.ID)
+	},
+}
+
+func init() {
+	ShowFlags.ID = show.Flags().StringP("id", "i", "", "migration id to show")
+}
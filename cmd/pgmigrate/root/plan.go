@@ -1,13 +1,23 @@
 package root
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"os"
 
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 
+	"github.com/peterldowns/pgmigrate"
 	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
 )
 
+var PlanFlags struct {
+	To      *string
+	ShowSQL *bool
+}
+
 var planCmd = &cobra.Command{ //nolint:gochecknoglobals
 	Use:   "plan",
 	Short: "Preview which migrations would be applied",
@@ -22,7 +32,8 @@ The migrations in the plan will be ordered by their IDs, in ascending
 lexicographical order. This is the same order that you see if you use "ls".
 This is also the same order that they will be applied in.
 
-The ID of a migration is its filename without the ".sql" suffix.
+The ID of a migration is its filename without the ".sql" suffix. Migrations that
+will be applied outside of a transaction are marked with "no_transaction=true".
 
 A migration will only ever be applied once. Editing the contents of the
 migration file will NOT result in it being re-applied. Instead, you will see a
@@ -52,37 +63,61 @@ of the time, when you're working with your coworkers, you will not write
 migrations that conflict with each other. As long as you use a migration
 name/number higher than that of any dependencies, you will not have any
 problems.
+
+With "--to <id>", the plan only includes the migrations whose IDs are less than
+or equal to the given ID, which must be the ID of a known migration. This shows
+which migrations "pgmigrate migrate --to <id>" would apply.
+
+With "--show-sql", the SQL of each migration is shown along with it, like the
+rest of its details. Migrations with a "-- pgmigrate:template" header are shown
+as they would be applied, after rendering them with the variables from "--var"
+flags and the config file.
 	`),
 	GroupID:          "migrating",
 	TraverseChildren: true,
 	RunE: func(cmd *cobra.Command, _ []string) error {
 		shared.State.Parse()
-		database := shared.State.Database()
 		migrations := shared.State.Migrations()
-		if err := shared.Validate(database, migrations); err != nil {
+		if err := shared.Validate(migrations); err != nil {
 			return err
 		}
-
-		slogger, mlogger := shared.State.Logger()
 		dir := os.DirFS(migrations.Value())
-		db, err := shared.OpenDB()
-		if err != nil {
-			return err
-		}
-		defer db.Close()
 
-		m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
-		if err != nil {
-			return err
-		}
-
-		plan, err := m.Plan(cmd.Context(), db)
-		if err != nil {
-			return err
-		}
-		for _, m := range plan {
-			slogger.With("checksum", m.MD5()).Info(m.ID)
-		}
-		return nil
+		return shared.ForEachDatabase(cmd.Context(), func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger shared.LogAdapter) (string, error) {
+			m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
+			if err != nil {
+				return "", err
+			}
+
+			var plan []pgmigrate.Migration
+			if *PlanFlags.To != "" {
+				plan, err = m.PlanTo(ctx, db, *PlanFlags.To)
+			} else {
+				plan, err = m.Plan(ctx, db)
+			}
+			if err != nil {
+				return "", err
+			}
+			for _, migration := range plan {
+				attrs := []any{"checksum", m.Checksum(migration)}
+				if migration.NoTransaction {
+					attrs = append(attrs, "no_transaction", true)
+				}
+				if *PlanFlags.ShowSQL {
+					query, err := m.Render(migration)
+					if err != nil {
+						return "", err
+					}
+					attrs = append(attrs, "sql", query)
+				}
+				slogger.With(attrs...).Info(migration.ID)
+			}
+			return fmt.Sprintf("%d pending", len(plan)), nil
+		})
 	},
 }
+
+func init() {
+	PlanFlags.ShowSQL = planCmd.Flags().Bool("show-sql", false, "also show the SQL of each migration, with any templates rendered")
+	PlanFlags.To = planCmd.Flags().String("to", "", "only plan the migrations up to and including the migration with this ID")
+}
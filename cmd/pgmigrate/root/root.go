@@ -6,6 +6,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/root/lock"
 	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/root/ops"
 	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
 )
@@ -21,6 +22,12 @@ pgmigrate migrate  # Apply any previously-unapplied migrations
 pgmigrate verify   # Verify that migrations have been applied correctly
 pgmigrate applied  # Show all previously-applied migrations
 
+# Test that migrations would apply, then roll them back
+pgmigrate migrate --dry-run
+
+# Apply migrations to every database in a file, 4 at a time
+pgmigrate migrate --databases-file shards.txt --concurrency 4
+
 # Dump the current schema to a file
 pgmigrate dump --out schema.sql
 	`),
@@ -46,6 +53,24 @@ func init() { //nolint:gochecknoinits
 		"",
 		"[PGM_DATABASE] a 'postgres://...' connection string",
 	)
+	shared.State.Flags.DatabasesFile = Command.PersistentFlags().String(
+		"databases-file",
+		"",
+		"[PGM_DATABASESFILE] a path to a file of 'postgres://...' connection strings, one per line, to run against instead of a single database",
+	)
+	shared.State.Flags.Concurrency = Command.PersistentFlags().Int(
+		"concurrency",
+		0,
+		"the maximum number of databases to run against at the same time (default 1)",
+	)
+	shared.State.Flags.OnError = Command.PersistentFlags().String(
+		"on-error",
+		"",
+		fmt.Sprintf(
+			"[PGM_ONERROR] '%s' or '%s', whether to stop running against more databases after one fails (default '%s')",
+			shared.OnErrorFailFast, shared.OnErrorContinue, shared.OnErrorFailFast,
+		),
+	)
 	shared.State.Flags.Migrations = Command.PersistentFlags().StringP(
 		"migrations",
 		"m",
@@ -65,6 +90,11 @@ func init() { //nolint:gochecknoinits
 			shared.LogFormatText, shared.LogFormatJSON, shared.LogFormatText,
 		),
 	)
+	shared.State.Flags.LogLevel = Command.PersistentFlags().String(
+		"log-level",
+		"",
+		"[PGM_LOGLEVEL] 'debug', 'info', 'warn', or 'error', the minimum level of log lines to show; 'debug' shows every query that pgmigrate runs and how long it took (default 'info')",
+	)
 	shared.State.Flags.TableName = Command.PersistentFlags().String(
 		"table-name",
 		"",
@@ -73,6 +103,58 @@ func init() { //nolint:gochecknoinits
 			pgmigrate.DefaultTableName,
 		),
 	)
+	shared.State.Flags.StrictOrdering = Command.PersistentFlags().Bool(
+		"strict-ordering",
+		false,
+		"[PGM_STRICTORDERING] if true, fail when an unapplied migration sorts before the latest applied migration",
+	)
+	shared.State.Flags.ChecksumAlgorithm = Command.PersistentFlags().String(
+		"checksum-algorithm",
+		"",
+		fmt.Sprintf(
+			"[PGM_CHECKSUMALGORITHM] '%s', '%s', or '%s', how migration checksums are calculated (default '%s')",
+			pgmigrate.ChecksumMD5, pgmigrate.ChecksumSHA256, pgmigrate.ChecksumNormalized, pgmigrate.ChecksumMD5,
+		),
+	)
+	shared.State.Flags.LockNamespace = Command.PersistentFlags().String(
+		"lock-namespace",
+		"",
+		fmt.Sprintf(
+			"[PGM_LOCKNAMESPACE] the namespace of the advisory lock held while migrating, which every migrator of the same table must share (default '%s')",
+			pgmigrate.DefaultLockNamespace,
+		),
+	)
+	shared.State.Flags.LockStrategy = Command.PersistentFlags().String(
+		"lock-strategy",
+		"",
+		fmt.Sprintf(
+			"[PGM_LOCKSTRATEGY] '%s' or '%s', how to make sure only one migrator runs at a time; use '%s' through PgBouncer in transaction pooling mode (default '%s')",
+			shared.LockStrategySession, shared.LockStrategyLease, shared.LockStrategyLease, shared.LockStrategySession,
+		),
+	)
+	shared.State.Flags.LockLeaseTable = Command.PersistentFlags().String(
+		"lock-lease-table",
+		"",
+		fmt.Sprintf("the table to keep leases in with --lock-strategy %s (default '%s')", shared.LockStrategyLease, pgmigrate.DefaultLeaseTableName),
+	)
+	shared.State.Flags.LockLeaseTTL = Command.PersistentFlags().Duration(
+		"lock-lease-ttl",
+		0,
+		fmt.Sprintf("how long a lease lasts without being renewed with --lock-strategy %s (default %s)", shared.LockStrategyLease, pgmigrate.DefaultLeaseTTL),
+	)
+	shared.State.Flags.HistoryTable = Command.PersistentFlags().String(
+		"history-table",
+		"",
+		fmt.Sprintf(
+			"[PGM_HISTORYTABLE] if set, the table to record every migration attempt and ops change in, like '%s'",
+			pgmigrate.DefaultHistoryTableName,
+		),
+	)
+	shared.State.Flags.Vars = Command.PersistentFlags().StringArray(
+		"var",
+		nil,
+		"a 'key=value' variable for templated migrations, can be passed multiple times",
+	)
 	_ = Command.MarkPersistentFlagDirname("migrations")
 
 	Command.AddGroup(
@@ -92,17 +174,20 @@ func init() { //nolint:gochecknoinits
 
 	// migrating
 	Command.AddCommand(appliedCmd)
+	Command.AddCommand(historyCmd)
 	Command.AddCommand(planCmd)
 	Command.AddCommand(verifyCmd)
 	Command.AddCommand(migrateCmd)
 
 	// ops
 	Command.AddCommand(ops.Command)
+	Command.AddCommand(lock.Command)
 	Command.AddCommand(versionCmd)
 
 	// dev
 	Command.AddCommand(configCmd)
 	Command.AddCommand(dumpCmd)
+	Command.AddCommand(lintCmd)
 	Command.AddCommand(newCmd)
 	Command.SetHelpCommandGroupID("dev")
 }
@@ -1,8 +1,13 @@
 package root
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 
 	"github.com/peterldowns/pgmigrate/cmd/pgmigrate/shared"
@@ -16,6 +21,8 @@ Warns about any migrations that:
 - are marked as applied in the database table but do not exist in the migrations
 directory
 - have a different checksum in the database than the current file hash
+- have not been applied, but sort before the latest applied migration (only
+with "--strict-ordering")
 
 If there are any warnings, exits with status code 1.
 Otherwise, succeeds without printing anything and exits with status code 0.
@@ -24,39 +31,40 @@ Otherwise, succeeds without printing anything and exits with status code 0.
 	TraverseChildren: true,
 	RunE: func(cmd *cobra.Command, _ []string) error {
 		shared.State.Parse()
-		database := shared.State.Database()
 		migrations := shared.State.Migrations()
-		if err := shared.Validate(database, migrations); err != nil {
+		if err := shared.Validate(migrations); err != nil {
 			return err
 		}
-
-		slogger, mlogger := shared.State.Logger()
 		dir := os.DirFS(migrations.Value())
-		db, err := shared.OpenDB()
-		if err != nil {
-			return err
-		}
-		defer db.Close()
 
-		m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
-		if err != nil {
-			return err
-		}
-
-		verrs, err := m.Verify(cmd.Context(), db)
-		if err != nil {
-			return err
-		}
-		for _, verr := range verrs {
-			var attrs []any
-			for key, val := range verr.Fields {
-				attrs = append(attrs, key, val)
+		err := shared.ForEachDatabase(cmd.Context(), func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger shared.LogAdapter) (string, error) {
+			m, err := newMigrator(dir, shared.State.TableName().Value(), mlogger)
+			if err != nil {
+				return "", err
 			}
-			slogger.With(attrs...).Warn(verr.Message)
-		}
-		if len(verrs) != 0 {
+			verrs, err := m.Verify(ctx, db)
+			if err != nil {
+				return "", err
+			}
+			for _, verr := range verrs {
+				attrs := []any{"kind", verr.Kind}
+				for key, val := range verr.Fields {
+					attrs = append(attrs, key, val)
+				}
+				slogger.With(attrs...).Warn(verr.Message)
+			}
+			if len(verrs) != 0 {
+				return fmt.Sprintf("%d warnings", len(verrs)), errVerificationFailed
+			}
+			return "verified", nil
+		})
+		if errors.Is(err, errVerificationFailed) {
 			os.Exit(1)
 		}
-		return nil
+		return err
 	},
 }
+
+// errVerificationFailed means that there were verification warnings, which
+// have already been printed.
+var errVerificationFailed = errors.New("found verification warnings")
@@ -0,0 +1,289 @@
+package shared
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+// NamedDatabase is one of the databases that commands run against when more
+// than one database is configured, see [ForEachDatabase].
+type NamedDatabase struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// OnError is what [ForEachDatabase] does after a command fails on one of the
+// databases.
+type OnError string
+
+const (
+	// OnErrorFailFast stops the command from starting on any more databases.
+	// Databases that the command is already running on are allowed to finish.
+	OnErrorFailFast OnError = "fail-fast"
+	// OnErrorContinue runs the command on every database regardless.
+	OnErrorContinue OnError = "continue"
+)
+
+// DatabaseResult is the outcome of running a command on one of the databases.
+type DatabaseResult struct {
+	Database string `json:"database"`
+	// Status is "ok", "failed", or "skipped" if the command was never started
+	// because an earlier database failed with [OnErrorFailFast].
+	Status string `json:"status"`
+	// Details is a short summary of what the command did, like "applied 2".
+	Details string `json:"details,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DatabaseFunc runs a command on a single database. The loggers include the
+// name of the database in every message when more than one database is
+// configured. It returns a short summary of what it did for the final report.
+type DatabaseFunc func(ctx context.Context, db *sql.DB, slogger *log.Logger, mlogger LogAdapter) (string, error)
+
+func (state StateT) DatabasesFile() Variable[string] {
+	return NewVariable(
+		"databases-file",
+		*state.Flags.DatabasesFile,
+		os.Getenv("PGM_DATABASESFILE"),
+		state.Config.DatabasesFile,
+		"", // default to missing
+	)
+}
+
+func (state StateT) Concurrency() Variable[int] {
+	return NewVariable(
+		"concurrency",
+		*state.Flags.Concurrency,
+		state.Config.Concurrency,
+		1, // default
+	)
+}
+
+func (state StateT) OnError() Variable[OnError] {
+	return NewVariable(
+		"on-error",
+		OnError(*state.Flags.OnError),
+		OnError(os.Getenv("PGM_ONERROR")),
+		state.Config.OnError,
+		OnErrorFailFast, // default
+	)
+}
+
+// Databases returns the named databases that commands should run against, or
+// nil if only a single database is configured. A database given with
+// "--database" or $PGM_DATABASE takes precedence over the list of databases
+// in the config file or the databases file.
+func (state StateT) Databases() ([]NamedDatabase, error) {
+	if *state.Flags.Database != "" || os.Getenv("PGM_DATABASE") != "" {
+		return nil, nil
+	}
+	databases := append([]NamedDatabase(nil), state.Config.Databases...)
+	if file := state.DatabasesFile(); file.IsSet() {
+		fromFile, err := readDatabasesFile(file.Value())
+		if err != nil {
+			return nil, err
+		}
+		databases = append(databases, fromFile...)
+	}
+	seen := map[string]bool{}
+	for i, database := range databases {
+		if database.URL == "" {
+			return nil, fmt.Errorf("database %q has no url", database.Name)
+		}
+		if database.Name == "" {
+			databases[i].Name = defaultDatabaseName(database.URL, i)
+		}
+		if seen[databases[i].Name] {
+			return nil, fmt.Errorf("duplicate database name %q", databases[i].Name)
+		}
+		seen[databases[i].Name] = true
+	}
+	return databases, nil
+}
+
+// readDatabasesFile reads a file with one database per line, either as just a
+// connection string or as a name and a connection string separated by
+// whitespace. Blank lines and lines starting with "#" are ignored.
+func readDatabasesFile(path string) ([]NamedDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open databases file: %w", err)
+	}
+	defer file.Close()
+	var databases []NamedDatabase
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		switch fields := strings.Fields(text); len(fields) {
+		case 1:
+			databases = append(databases, NamedDatabase{URL: fields[0]})
+		case 2:
+			databases = append(databases, NamedDatabase{Name: fields[0], URL: fields[1]})
+		default:
+			return nil, fmt.Errorf("databases file %s:%d: expected a connection string, optionally preceded by a name", path, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read databases file: %w", err)
+	}
+	return databases, nil
+}
+
+// defaultDatabaseName names the i-th database by the host and database name of
+// its connection string, which is either a "postgres://..." URL or a set of
+// "key=value" settings. The name never includes the user, the password, or any
+// other parameters. If neither can be found, the database is named by its
+// position instead, like "database-3".
+func defaultDatabaseName(connstr string, i int) string {
+	var host, dbname string
+	if eurl, err := url.Parse(connstr); err == nil && (eurl.Scheme == "postgres" || eurl.Scheme == "postgresql") {
+		query := eurl.Query()
+		host = cmp.Or(eurl.Host, query.Get("host"))
+		dbname = cmp.Or(strings.TrimPrefix(eurl.Path, "/"), query.Get("dbname"))
+	} else if settings, ok := parseKeyValueSettings(connstr); ok {
+		host = settings["host"]
+		if port := settings["port"]; host != "" && port != "" {
+			host += ":" + port
+		}
+		dbname = settings["dbname"]
+	}
+	if host == "" && dbname == "" {
+		return fmt.Sprintf("database-%d", i+1)
+	}
+	return host + "/" + dbname
+}
+
+// parseKeyValueSettings parses a "key=value key=value" connection string. It
+// does not support quoted values, and returns false if the connection string
+// has any.
+func parseKeyValueSettings(connstr string) (map[string]string, bool) {
+	settings := map[string]string{}
+	for _, field := range strings.Fields(connstr) {
+		key, value, found := strings.Cut(field, "=")
+		if !found || key == "" || strings.ContainsAny(value, `'\`) {
+			return nil, false
+		}
+		settings[key] = value
+	}
+	return settings, len(settings) != 0
+}
+
+// ForEachDatabase runs fn on every configured database.
+//
+// If there is only a single database, fn is called once and its error is
+// returned as-is. Otherwise, fn is called on up to "--concurrency" databases
+// at a time, following the "--on-error" policy, and a report of the result
+// for each database is printed at the end: a table, or a JSON array with
+// "--log-format json". If fn failed on any database, the returned error says
+// how many.
+func ForEachDatabase(ctx context.Context, fn DatabaseFunc) error {
+	databases, err := State.Databases()
+	if err != nil {
+		return err
+	}
+	slogger, mlogger := State.Logger()
+	if len(databases) == 0 {
+		if err := Validate(State.Database()); err != nil {
+			return err
+		}
+		db, err := OpenDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		_, err = fn(ctx, db, slogger, mlogger)
+		return err
+	}
+
+	onError := State.OnError().Value()
+	if onError != OnErrorFailFast && onError != OnErrorContinue {
+		return fmt.Errorf(`unknown --on-error "%s", must be "%s" or "%s"`, onError, OnErrorFailFast, OnErrorContinue)
+	}
+	concurrency := max(State.Concurrency().Value(), 1)
+	results := make([]DatabaseResult, len(databases))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+	for i, database := range databases {
+		semaphore <- struct{}{}
+		mu.Lock()
+		stop := failed && onError == OnErrorFailFast
+		mu.Unlock()
+		if stop {
+			<-semaphore
+			results[i] = DatabaseResult{Database: database.Name, Status: "skipped"}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			logger := slogger.With("database", database.Name)
+			result := DatabaseResult{Database: database.Name, Status: "ok"}
+			details, err := runOnDatabase(ctx, database, fn, logger, NewLogAdapter(logger))
+			result.Details = details
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+				logger.Error("failed", "error", err)
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	if err := printDatabaseResults(results); err != nil {
+		return err
+	}
+	count := 0
+	for _, result := range results {
+		if result.Status != "ok" {
+			count++
+		}
+	}
+	if count != 0 {
+		return fmt.Errorf("%d of %d databases failed or were skipped", count, len(results))
+	}
+	return nil
+}
+
+func runOnDatabase(ctx context.Context, database NamedDatabase, fn DatabaseFunc, slogger *log.Logger, mlogger LogAdapter) (string, error) {
+	db, err := OpenURL(database.URL)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	return fn(ctx, db, slogger, mlogger)
+}
+
+func printDatabaseResults(results []DatabaseResult) error {
+	if State.LogFormat().Value() == LogFormatJSON {
+		return json.NewEncoder(os.Stdout).Encode(results)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tSTATUS\tDETAILS\tERROR")
+	for _, result := range results {
+		// Keep each result on a single line, even if its error has several.
+		errorText := strings.Join(strings.Fields(result.Error), " ")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Database, result.Status, result.Details, errorText)
+	}
+	return w.Flush()
+}
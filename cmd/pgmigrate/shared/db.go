@@ -14,7 +14,13 @@ func OpenDB() (*sql.DB, error) {
 	if err := Validate(dbVar); err != nil {
 		return nil, err
 	}
-	dbStr, err := setDefaultStatementCachingParameter(dbVar.Value())
+	return OpenURL(dbVar.Value())
+}
+
+// OpenURL opens a connection pool to the database with the given connection
+// string.
+func OpenURL(connstr string) (*sql.DB, error) {
+	dbStr, err := setDefaultStatementCachingParameter(connstr)
 	if err != nil {
 		return nil, err
 	}
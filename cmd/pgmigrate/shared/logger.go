@@ -1,7 +1,7 @@
 package shared
 
 import (
-	"context"
+	"log/slog"
 
 	"github.com/charmbracelet/log"
 
@@ -15,23 +15,14 @@ const (
 	LogFormatText LogFormat = "text"
 )
 
+// LogAdapter is the [pgmigrate.Logger] that commands use. It writes to the
+// charmbracelet logger, which is also a [slog.Handler], so its level decides
+// which of pgmigrate's messages are shown.
 type LogAdapter struct {
-	*log.Logger
+	pgmigrate.SlogLogger
 }
 
-func (l LogAdapter) Log(_ context.Context, level pgmigrate.LogLevel, msg string, fields ...pgmigrate.LogField) {
-	args := make([]any, 0, 2*len(fields))
-	for _, field := range fields {
-		args = append(args, field.Key, field.Value)
-	}
-	switch level {
-	case pgmigrate.LogLevelDebug:
-		l.Logger.Debug(msg, args...)
-	case pgmigrate.LogLevelInfo:
-		l.Logger.Info(msg, args...)
-	case pgmigrate.LogLevelError:
-		l.Logger.Error(msg, args...)
-	case pgmigrate.LogLevelWarning:
-		l.Logger.Warn(msg, args...)
-	}
+// NewLogAdapter returns a [LogAdapter] that writes to logger.
+func NewLogAdapter(logger *log.Logger) LogAdapter {
+	return LogAdapter{pgmigrate.NewSlogLogger(slog.New(logger))}
 }
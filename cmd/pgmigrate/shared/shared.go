@@ -8,6 +8,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -18,18 +19,65 @@ import (
 )
 
 type Flags struct {
-	LogFormat  *string // see logger.go
-	Database   *string // see root.go
-	Migrations *string // see root.go
-	TableName  *string // see root.go
-	ConfigFile *string // see root.go
+	LogFormat         *string        // see logger.go
+	LogLevel          *string        // see logger.go
+	Database          *string        // see root.go
+	Migrations        *string        // see root.go
+	TableName         *string        // see root.go
+	ConfigFile        *string        // see root.go
+	StrictOrdering    *bool          // see root.go
+	ChecksumAlgorithm *string        // see root.go
+	Vars              *[]string      // see root.go
+	DatabasesFile     *string        // see root.go
+	Concurrency       *int           // see root.go
+	OnError           *string        // see root.go
+	LockNamespace     *string        // see root.go
+	LockStrategy      *string        // see root.go
+	LockLeaseTable    *string        // see root.go
+	LockLeaseTTL      *time.Duration // see root.go
+	HistoryTable      *string        // see root.go
 }
 type Config struct {
-	Database   string            `yaml:"database"`
-	Migrations string            `yaml:"migrations"`
-	LogFormat  LogFormat         `yaml:"log_format"`
-	TableName  string            `yaml:"table_name"`
-	Dump       schema.DumpConfig `yaml:"dump"`
+	Database          string                      `yaml:"database"`
+	Databases         []NamedDatabase             `yaml:"databases"`
+	DatabasesFile     string                      `yaml:"databases_file"`
+	Concurrency       int                         `yaml:"concurrency"`
+	OnError           OnError                     `yaml:"on_error"`
+	Migrations        string                      `yaml:"migrations"`
+	LogFormat         LogFormat                   `yaml:"log_format"`
+	LogLevel          string                      `yaml:"log_level"`
+	TableName         string                      `yaml:"table_name"`
+	StrictOrdering    bool                        `yaml:"strict_ordering"`
+	ChecksumAlgorithm pgmigrate.ChecksumAlgorithm `yaml:"checksum_algorithm"`
+	LockNamespace     string                      `yaml:"lock_namespace"`
+	LockStrategy      LockStrategy                `yaml:"lock_strategy"`
+	LockLeaseTable    string                      `yaml:"lock_lease_table"`
+	LockLeaseTTL      time.Duration               `yaml:"lock_lease_ttl"`
+	HistoryTable      string                      `yaml:"history_table"`
+	Vars              map[string]string           `yaml:"vars"`
+	Migrate           MigrateConfig               `yaml:"migrate"`
+	Dump              schema.DumpConfig           `yaml:"dump"`
+}
+
+// MigrateConfig holds the options for "pgmigrate migrate", see migrate.go.
+type MigrateConfig struct {
+	LockTimeout          time.Duration `yaml:"lock_timeout"`
+	StatementTimeout     time.Duration `yaml:"statement_timeout"`
+	LockRetryMaxAttempts int           `yaml:"lock_retry_max_attempts"`
+	LockRetryBackoff     time.Duration `yaml:"lock_retry_backoff"`
+	LockMaxWait          time.Duration `yaml:"lock_max_wait"`
+	LockNoWait           bool          `yaml:"lock_no_wait"`
+	LockSpinInterval     time.Duration `yaml:"lock_spin_interval"`
+	Atomic               bool          `yaml:"atomic"`
+	Tenants              TenantsConfig `yaml:"tenants"`
+}
+
+// TenantsConfig holds the options for "pgmigrate migrate --tenants", see
+// migrate.go.
+type TenantsConfig struct {
+	Schemas     []string `yaml:"schemas"`
+	Query       string   `yaml:"query"`
+	Concurrency int      `yaml:"concurrency"`
 }
 
 type StateT struct {
@@ -90,6 +138,16 @@ func (state StateT) LogFormat() Variable[LogFormat] {
 	)
 }
 
+func (state StateT) LogLevel() Variable[string] {
+	return NewVariable(
+		"log-level",
+		*state.Flags.LogLevel,
+		os.Getenv("PGM_LOGLEVEL"),
+		state.Config.LogLevel,
+		log.InfoLevel.String(), // default
+	)
+}
+
 func (state StateT) Migrations() Variable[string] {
 	return NewVariable(
 		"migrations",
@@ -110,6 +168,104 @@ func (state StateT) TableName() Variable[string] {
 	)
 }
 
+func (state StateT) StrictOrdering() Variable[bool] {
+	return NewVariable(
+		"strict-ordering",
+		*state.Flags.StrictOrdering,
+		os.Getenv("PGM_STRICTORDERING") == "true",
+		state.Config.StrictOrdering,
+		false, // default
+	)
+}
+
+// Vars returns the variables that templated migrations are rendered with.
+// Each "--var key=value" flag overrides the variable of the same name from the
+// config file.
+func (state StateT) Vars() (map[string]string, error) {
+	vars := make(map[string]string, len(state.Config.Vars))
+	for key, value := range state.Config.Vars {
+		vars[key] = value
+	}
+	for _, flag := range *state.Flags.Vars {
+		key, value, ok := strings.Cut(flag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf(`invalid --var "%s", must be in the form key=value`, flag)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+func (state StateT) ChecksumAlgorithm() Variable[pgmigrate.ChecksumAlgorithm] {
+	return NewVariable(
+		"checksum-algorithm",
+		pgmigrate.ChecksumAlgorithm(*state.Flags.ChecksumAlgorithm),
+		pgmigrate.ChecksumAlgorithm(os.Getenv("PGM_CHECKSUMALGORITHM")),
+		state.Config.ChecksumAlgorithm,
+		pgmigrate.ChecksumMD5, // default
+	)
+}
+
+func (state StateT) LockNamespace() Variable[string] {
+	return NewVariable(
+		"lock-namespace",
+		*state.Flags.LockNamespace,
+		os.Getenv("PGM_LOCKNAMESPACE"),
+		state.Config.LockNamespace,
+		pgmigrate.DefaultLockNamespace, // default
+	)
+}
+
+// HistoryTable is the table that records every change that commands make, see
+// [pgmigrate.Migrator.HistoryTableName]. It is empty, and no history is
+// recorded, unless it is configured.
+func (state StateT) HistoryTable() Variable[string] {
+	return NewVariable(
+		"history-table",
+		*state.Flags.HistoryTable,
+		os.Getenv("PGM_HISTORYTABLE"),
+		state.Config.HistoryTable,
+		"", // default to missing
+	)
+}
+
+// LockStrategy is the kind of [pgmigrate.Locker] that commands use.
+type LockStrategy string
+
+const (
+	LockStrategySession LockStrategy = "session"
+	LockStrategyLease   LockStrategy = "lease"
+)
+
+func (state StateT) LockStrategy() Variable[LockStrategy] {
+	return NewVariable(
+		"lock-strategy",
+		LockStrategy(*state.Flags.LockStrategy),
+		LockStrategy(os.Getenv("PGM_LOCKSTRATEGY")),
+		state.Config.LockStrategy,
+		LockStrategySession, // default
+	)
+}
+
+// Locker returns the [pgmigrate.Locker] for the configured lock strategy.
+func (state StateT) Locker() (pgmigrate.Locker, error) {
+	switch strategy := state.LockStrategy().Value(); strategy {
+	case LockStrategySession:
+		return pgmigrate.SessionLocker{}, nil
+	case LockStrategyLease:
+		locker := pgmigrate.NewLeaseLocker()
+		if table := NewVariable("lock-lease-table", *state.Flags.LockLeaseTable, state.Config.LockLeaseTable); table.IsSet() {
+			locker.TableName = table.Value()
+		}
+		if ttl := NewVariable("lock-lease-ttl", *state.Flags.LockLeaseTTL, state.Config.LockLeaseTTL); ttl.IsSet() {
+			locker.TTL = ttl.Value()
+		}
+		return locker, nil
+	default:
+		return nil, fmt.Errorf(`unknown --lock-strategy "%s", must be "%s" or "%s"`, strategy, LockStrategySession, LockStrategyLease)
+	}
+}
+
 func (state StateT) Logger() (*log.Logger, LogAdapter) {
 	var logger *log.Logger
 	format := state.LogFormat().Value()
@@ -121,7 +277,12 @@ func (state StateT) Logger() (*log.Logger, LogAdapter) {
 	default:
 		panic(fmt.Errorf("unknown log format: %s", format))
 	}
-	return logger, LogAdapter{logger}
+	level, err := log.ParseLevel(state.LogLevel().Value())
+	if err != nil {
+		panic(fmt.Errorf("unknown log level: %w", err))
+	}
+	logger.SetLevel(level)
+	return logger, NewLogAdapter(logger)
 }
 
 func RepoPath(p string) string {
@@ -0,0 +1,98 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// directivePrefix marks a comment in the header of a migration file as a
+// pgmigrate directive, for example:
+//
+//	-- pgmigrate:no-transaction
+const directivePrefix = "pgmigrate:"
+
+// Supported directives, see [Migration] for the meaning of each one.
+const (
+	directiveNoTransaction    = "no-transaction"
+	directiveLockTimeout      = "lock_timeout"
+	directiveStatementTimeout = "statement_timeout"
+	directiveTemplate         = "template"
+)
+
+// parseDirectives reads any directives from the header of a migration's SQL and
+// sets the corresponding fields on the migration. The header is every line at
+// the start of the file that is either blank or a `--` comment; directives that
+// appear after the first line of SQL are ignored.
+//
+// An unknown directive is an error, so that a typo cannot silently change the
+// way that a migration is applied.
+func parseDirectives(migration *Migration) error {
+	for _, line := range strings.Split(migration.SQL, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(comment, directivePrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(comment, directivePrefix))
+		if len(fields) == 0 {
+			return fmt.Errorf("%s: empty directive", migration.ID)
+		}
+		name, args := fields[0], fields[1:]
+		switch name {
+		case directiveNoTransaction:
+			if len(args) != 0 {
+				return fmt.Errorf("%s: directive %q does not accept a value", migration.ID, name)
+			}
+			migration.NoTransaction = true
+		case directiveLockTimeout:
+			timeout, err := parseTimeoutDirective(name, args)
+			if err != nil {
+				return fmt.Errorf("%s: %w", migration.ID, err)
+			}
+			migration.LockTimeout = timeout
+		case directiveStatementTimeout:
+			timeout, err := parseTimeoutDirective(name, args)
+			if err != nil {
+				return fmt.Errorf("%s: %w", migration.ID, err)
+			}
+			migration.StatementTimeout = timeout
+		case directiveTemplate:
+			if len(args) != 0 {
+				return fmt.Errorf("%s: directive %q does not accept a value", migration.ID, name)
+			}
+			migration.Template = true
+		default:
+			return fmt.Errorf("%s: unknown directive %q", migration.ID, name)
+		}
+	}
+	return nil
+}
+
+// parseTimeoutDirective parses the value of a directive like
+// `-- pgmigrate:lock_timeout 5s`, which must be a single duration of at least
+// 1ms in the format accepted by [time.ParseDuration].
+func parseTimeoutDirective(name string, args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("directive %q requires a single duration, like \"5s\"", name)
+	}
+	timeout, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("directive %q: %w", name, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("directive %q: duration must be positive", name)
+	}
+	if timeout < time.Millisecond {
+		// Postgres timeouts have millisecond precision, and a timeout of 0ms
+		// means that there is no timeout at all.
+		return 0, fmt.Errorf("directive %q: duration must be at least 1ms", name)
+	}
+	return timeout, nil
+}
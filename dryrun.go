@@ -0,0 +1,136 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/peterldowns/pgmigrate/internal/multierr"
+)
+
+// DryRunStatus describes what happened to a migration during a dry run.
+type DryRunStatus string
+
+const (
+	// DryRunSucceeded means that the migration was applied successfully
+	// before being rolled back.
+	DryRunSucceeded DryRunStatus = "succeeded"
+	// DryRunFailed means that the migration failed to apply.
+	DryRunFailed DryRunStatus = "failed"
+	// DryRunSkipped means that the migration was not attempted, see
+	// [DryRunResult.SkipReason] for why.
+	DryRunSkipped DryRunStatus = "skipped"
+)
+
+// DryRunResult is the outcome of a single migration during a dry run.
+type DryRunResult struct {
+	Migration
+	// Status is whether the migration succeeded, failed, or was skipped.
+	Status DryRunStatus
+	// ExecutionTimeInMillis is how long the migration took to apply or fail.
+	// It is 0 for skipped migrations.
+	ExecutionTimeInMillis int64
+	// Error is the reason that the migration failed, if it did.
+	Error error
+	// SkipReason is the reason that the migration was skipped, if it was.
+	SkipReason string
+}
+
+// DryRun tests whether the migrations in the plan would apply successfully,
+// without leaving any trace of them in the database.
+//
+// Like [Migrator.Migrate], it acquires the migrations lock and calculates a
+// plan. Then, it begins a single transaction and applies each migration in the
+// plan inside of it, recording how long each one took. Finally, the
+// transaction is always rolled back, whether or not the migrations succeeded.
+//
+// Because the migrations share one transaction, DryRun stops at the first
+// migration that fails; the migrations after it are reported as skipped, and
+// the failure is returned as an error along with the results. Migrations
+// marked as [Migration.NoTransaction] cannot be run inside of a transaction,
+// so they are always reported as skipped. [Hooks] are not called during a dry
+// run, and migrations are not retried if they fail to acquire a lock.
+func (m *Migrator) DryRun(ctx context.Context, db *sql.DB) ([]DryRunResult, error) {
+	var results []DryRunResult
+	err := m.locker().WithLock(ctx, db, m.lockName(), m.lockOptions(), func(ctx context.Context, conn *sql.Conn) (final error) {
+		plan, err := m.Plan(ctx, conn)
+		if err != nil {
+			return err
+		}
+		m.info(ctx, fmt.Sprintf("dry run: planning to apply %d migrations", len(plan)))
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			msg := "tx open"
+			m.error(ctx, err, msg)
+			return fmt.Errorf("%s: %w", msg, err)
+		}
+		defer func() {
+			m.info(ctx, "dry run: rolling back")
+			if err := tx.Rollback(); err != nil {
+				final = multierr.Join(final, fmt.Errorf("tx rollback: %w", err))
+			}
+		}()
+		if err := m.ensureMigrationsTable(ctx, tx); err != nil {
+			return err
+		}
+		results, err = m.dryRun(ctx, tx, plan)
+		return err
+	})
+	return results, err
+}
+
+// dryRun applies each migration in the plan inside of the given transaction,
+// stopping at the first failure.
+func (m *Migrator) dryRun(ctx context.Context, tx *sql.Tx, plan []Migration) ([]DryRunResult, error) {
+	results := make([]DryRunResult, 0, len(plan))
+	var failure error
+	for _, migration := range plan {
+		result := DryRunResult{Migration: migration}
+		switch {
+		case failure != nil:
+			result.Status = DryRunSkipped
+			result.SkipReason = "a previous migration failed"
+		case migration.NoTransaction:
+			result.Status = DryRunSkipped
+			result.SkipReason = "no-transaction migrations cannot be run inside of a transaction"
+		default:
+			result.ExecutionTimeInMillis, result.Error = m.dryRunMigration(ctx, tx, migration)
+			if result.Error != nil {
+				result.Status = DryRunFailed
+				failure = fmt.Errorf("dry run of %s: %w", migration.ID, result.Error)
+			} else {
+				result.Status = DryRunSucceeded
+			}
+		}
+		if result.Status == DryRunSkipped {
+			m.info(ctx, "dry run: skipping migration",
+				LogField{Key: "migration_id", Value: migration.ID},
+				LogField{Key: "reason", Value: result.SkipReason},
+			)
+		}
+		results = append(results, result)
+	}
+	return results, failure
+}
+
+// dryRunMigration applies a single migration inside of the dry run's
+// transaction, and returns how long it took.
+func (m *Migrator) dryRunMigration(ctx context.Context, tx *sql.Tx, migration Migration) (int64, error) {
+	startedAt := time.Now().UTC()
+	fields := []LogField{
+		{Key: "migration_id", Value: migration.ID},
+		{Key: "migration_checksum", Value: m.Checksum(migration)},
+		{Key: "started_at", Value: startedAt},
+		{Key: "dry_run", Value: true},
+	}
+	settings := m.timeoutSettings(migration)
+	for _, setting := range settings {
+		fields = append(fields, LogField{Key: setting.name, Value: setting.value})
+	}
+	m.info(ctx, "applying migration", fields...)
+	err := m.execInSharedTx(ctx, tx, migration, settings)
+	executionTimeMs := time.Since(startedAt).Milliseconds()
+	_, err = m.migrationResult(ctx, migration, startedAt, &fields, err)
+	return executionTimeMs, err
+}
@@ -0,0 +1,84 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestDryRunAlwaysRollsBack(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_insert", SQL: "INSERT INTO users (name) VALUES ('alice')"},
+			{ID: "0003_index", SQL: "CREATE INDEX CONCURRENTLY users_name_idx ON users (name)", NoTransaction: true},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		results, err := migrator.DryRun(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(results))
+		check.Equal(t, "0001_initial", results[0].ID)
+		check.Equal(t, pgmigrate.DryRunSucceeded, results[0].Status)
+		check.Equal(t, "0002_insert", results[1].ID)
+		check.Equal(t, pgmigrate.DryRunSucceeded, results[1].Status)
+		check.Equal(t, "0003_index", results[2].ID)
+		check.Equal(t, pgmigrate.DryRunSkipped, results[2].Status)
+		check.NotEqual(t, "", results[2].SkipReason)
+
+		// Nothing was left behind, not even the migrations table.
+		var exists bool
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT to_regclass('public.users') IS NOT NULL").Scan(&exists))
+		check.False(t, exists)
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", migrator.TableName).Scan(&exists))
+		check.False(t, exists)
+
+		// The migrations are still planned and can be applied for real.
+		plan, err := migrator.Plan(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 3, len(plan))
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDryRunStopsAtFirstFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_invalid", SQL: "INSERT INTO missing_table (name) VALUES ('alice')"},
+			{ID: "0003_never_attempted", SQL: "CREATE TABLE companies (name text)"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		results, err := migrator.DryRun(ctx, db)
+		check.Error(t, err)
+		assert.Equal(t, 3, len(results))
+		check.Equal(t, pgmigrate.DryRunSucceeded, results[0].Status)
+		check.Nil(t, results[0].Error)
+		check.Equal(t, pgmigrate.DryRunFailed, results[1].Status)
+		check.Error(t, results[1].Error)
+		check.Equal(t, pgmigrate.DryRunSkipped, results[2].Status)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,166 @@
+package pgmigrate
+
+import (
+	"context"
+	"time"
+)
+
+// Event is something that happened while a [Migrator] applied migrations, see
+// [Migrator.OnEvent]. It is one of
+//   - [PlanComputed]
+//   - [LockWaiting]
+//   - [LockAcquired]
+//   - [MigrationStarted]
+//   - [MigrationSucceeded]
+//   - [MigrationFailed]
+//   - [VerificationIssues]
+//
+// Use a type switch to handle the events that you're interested in.
+type Event interface {
+	isEvent()
+}
+
+// PlanComputed is sent once the migrations lock has been acquired and the
+// plan has been calculated, before any migrations are applied. It is sent even
+// if the plan is empty.
+type PlanComputed struct {
+	Plan []Migration
+}
+
+// LockWaiting is sent while waiting for another migrator to release the
+// migrations lock, once right away and then every
+// [Migrator.LockWaitLogInterval]. It is not sent if that interval is 0.
+type LockWaiting struct {
+	// Waited is how long the migrator has been waiting.
+	Waited time.Duration
+	// Holders are the sessions that hold the lock, if they could be found.
+	Holders []LockHolder
+}
+
+// LockAcquired is sent once the migrations lock has been acquired.
+type LockAcquired struct {
+	// Waited is how long it took to acquire the lock.
+	Waited time.Duration
+}
+
+// MigrationStarted is sent before each attempt to apply a migration.
+type MigrationStarted struct {
+	Migration Migration
+	// Attempt is 1 for the first attempt, and counts up for each retry, see
+	// [Migrator.LockRetryMaxAttempts].
+	Attempt int
+}
+
+// MigrationSucceeded is sent after a migration has been applied and committed.
+// If [Migrator.SingleTransaction] is set, it is sent for every migration in
+// the plan once the transaction has been committed.
+type MigrationSucceeded struct {
+	Applied AppliedMigration
+	// Duration is how long it took to apply the migration.
+	Duration time.Duration
+}
+
+// MigrationFailed is sent after an attempt to apply a migration failed. If the
+// attempt is going to be retried, a [MigrationStarted] with the next Attempt
+// follows.
+type MigrationFailed struct {
+	Migration Migration
+	Attempt   int
+	// Duration is how long the attempt ran before it failed.
+	Duration time.Duration
+	// Err is the reason that the migration failed.
+	Err error
+	// RolledBack is true if the migration itself succeeded, but was rolled
+	// back because another migration in the same transaction failed, see
+	// [Migrator.SingleTransaction]. Err is then the other migration's error.
+	RolledBack bool
+}
+
+// VerificationIssues is sent after the migrations have been applied if
+// [Migrator.Verify] found any problems.
+type VerificationIssues struct {
+	Errors []VerificationError
+}
+
+func (PlanComputed) isEvent()       {}
+func (LockWaiting) isEvent()        {}
+func (LockAcquired) isEvent()       {}
+func (MigrationStarted) isEvent()   {}
+func (MigrationSucceeded) isEvent() {}
+func (MigrationFailed) isEvent()    {}
+func (VerificationIssues) isEvent() {}
+
+// emit sends the event to [Migrator.OnEvent], if it is set.
+func (m *Migrator) emit(ctx context.Context, event Event) {
+	if m.OnEvent != nil {
+		m.OnEvent(ctx, event)
+	}
+}
+
+// Counter is a metric that only goes up. It is satisfied by
+// prometheus.Counter, among others.
+type Counter interface {
+	Inc()
+}
+
+// Histogram is a metric that records a distribution of values. It is satisfied
+// by prometheus.Histogram, among others.
+type Histogram interface {
+	Observe(float64)
+}
+
+// Metrics is an adapter that records a [Migrator]'s events as metrics. Create
+// the metrics in your own metrics library, register them however you usually
+// do, and set [Migrator.OnEvent] to [Metrics.OnEvent]. For example, with the
+// Prometheus client:
+//
+//	applied := prometheus.NewCounter(prometheus.CounterOpts{
+//		Name: "pgmigrate_migrations_applied_total",
+//	})
+//	registry.MustRegister(applied)
+//	metrics := pgmigrate.Metrics{MigrationsApplied: applied}
+//	migrator.OnEvent = metrics.OnEvent
+//
+// Any of the metrics may be nil. Durations are observed in seconds.
+type Metrics struct {
+	// MigrationsApplied counts the migrations that were applied.
+	MigrationsApplied Counter
+	// MigrationsFailed counts the failed attempts to apply a migration,
+	// including attempts that were retried and migrations that were rolled
+	// back because another migration in the same transaction failed.
+	MigrationsFailed Counter
+	// MigrationDuration observes how long each migration took to apply.
+	MigrationDuration Histogram
+	// LockWait observes how long it took to acquire the migrations lock.
+	LockWait Histogram
+	// VerificationIssues counts the problems found by [Migrator.Verify] after
+	// applying migrations.
+	VerificationIssues Counter
+}
+
+// OnEvent records the event in the metrics, see [Migrator.OnEvent].
+func (metrics Metrics) OnEvent(_ context.Context, event Event) {
+	switch event := event.(type) {
+	case LockAcquired:
+		if metrics.LockWait != nil {
+			metrics.LockWait.Observe(event.Waited.Seconds())
+		}
+	case MigrationSucceeded:
+		if metrics.MigrationsApplied != nil {
+			metrics.MigrationsApplied.Inc()
+		}
+		if metrics.MigrationDuration != nil {
+			metrics.MigrationDuration.Observe(event.Duration.Seconds())
+		}
+	case MigrationFailed:
+		if metrics.MigrationsFailed != nil {
+			metrics.MigrationsFailed.Inc()
+		}
+	case VerificationIssues:
+		if metrics.VerificationIssues != nil {
+			for range event.Errors {
+				metrics.VerificationIssues.Inc()
+			}
+		}
+	}
+}
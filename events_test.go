@@ -0,0 +1,125 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestOnEvent(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_broken", SQL: "SELECT * FROM does_not_exist;"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		var events []pgmigrate.Event
+		migrator.OnEvent = func(_ context.Context, event pgmigrate.Event) {
+			events = append(events, event)
+		}
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		assert.Equal(t, 6, len(events))
+		_, ok := events[0].(pgmigrate.LockAcquired)
+		check.True(t, ok)
+		plan, ok := events[1].(pgmigrate.PlanComputed)
+		check.True(t, ok)
+		check.Equal(t, 2, len(plan.Plan))
+		started, ok := events[2].(pgmigrate.MigrationStarted)
+		check.True(t, ok)
+		check.Equal(t, "0001_users", started.Migration.ID)
+		check.Equal(t, 1, started.Attempt)
+		succeeded, ok := events[3].(pgmigrate.MigrationSucceeded)
+		check.True(t, ok)
+		check.Equal(t, "0001_users", succeeded.Applied.ID)
+		started, ok = events[4].(pgmigrate.MigrationStarted)
+		check.True(t, ok)
+		check.Equal(t, "0002_broken", started.Migration.ID)
+		failed, ok := events[5].(pgmigrate.MigrationFailed)
+		check.True(t, ok)
+		check.Equal(t, "0002_broken", failed.Migration.ID)
+		check.Error(t, failed.Err)
+		check.False(t, failed.RolledBack)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestOnEventVerificationIssues(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = logger
+		_, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+
+		// The applied migration no longer exists.
+		migrator.Migrations = nil
+		var issues []pgmigrate.VerificationIssues
+		migrator.OnEvent = func(_ context.Context, event pgmigrate.Event) {
+			if event, ok := event.(pgmigrate.VerificationIssues); ok {
+				issues = append(issues, event)
+			}
+		}
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(verrs))
+		assert.Equal(t, 1, len(issues))
+		check.Equal(t, verrs, issues[0].Errors)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+type fakeCounter struct{ count int }
+
+func (c *fakeCounter) Inc() { c.count++ }
+
+type fakeHistogram struct{ values []float64 }
+
+func (h *fakeHistogram) Observe(value float64) { h.values = append(h.values, value) }
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	applied, failed, issues := &fakeCounter{}, &fakeCounter{}, &fakeCounter{}
+	duration, lockWait := &fakeHistogram{}, &fakeHistogram{}
+	metrics := pgmigrate.Metrics{
+		MigrationsApplied:  applied,
+		MigrationsFailed:   failed,
+		MigrationDuration:  duration,
+		LockWait:           lockWait,
+		VerificationIssues: issues,
+	}
+	metrics.OnEvent(ctx, pgmigrate.LockAcquired{Waited: 2 * time.Second})
+	metrics.OnEvent(ctx, pgmigrate.PlanComputed{})
+	metrics.OnEvent(ctx, pgmigrate.MigrationStarted{Attempt: 1})
+	metrics.OnEvent(ctx, pgmigrate.MigrationSucceeded{Duration: 500 * time.Millisecond})
+	metrics.OnEvent(ctx, pgmigrate.MigrationFailed{Attempt: 1})
+	metrics.OnEvent(ctx, pgmigrate.VerificationIssues{Errors: make([]pgmigrate.VerificationError, 2)})
+
+	check.Equal(t, 1, applied.count)
+	check.Equal(t, 1, failed.count)
+	check.Equal(t, 2, issues.count)
+	check.Equal(t, []float64{0.5}, duration.values)
+	check.Equal(t, []float64{2}, lockWait.values)
+
+	// Metrics that aren't set are skipped.
+	pgmigrate.Metrics{}.OnEvent(ctx, pgmigrate.MigrationSucceeded{})
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"log/slog"
 	"os"
 	"time"
 
@@ -51,7 +52,9 @@ var migrationsFS embed.FS
 
 // Does what it says!
 func applyMigrations(ctx context.Context, db *sql.DB, logger *log.Logger) error {
-	verrs, err := pgmigrate.Migrate(ctx, db, migrationsFS, logAdapter{logger})
+	// The charm/log logger is also a slog.Handler, so pgmigrate's slog
+	// adapter can write to it.
+	verrs, err := pgmigrate.Migrate(ctx, db, migrationsFS, pgmigrate.NewSlogLogger(slog.New(logger)))
 	if err != nil {
 		return err
 	}
@@ -76,33 +79,3 @@ func runServer(_ context.Context, _ *sql.DB, logger *log.Logger) {
 		logger.Info("tick")
 	}
 }
-
-// This is an unavoidable annoyance -- in order to make pgmigrate work with
-// various different logging libraries (zap, slog, logrus, etc.) it requires
-// you to adapt your logger to its interface. This wraps the charm/log logger
-// so that we can see the pgmigrate logs when the app starts up.
-type logAdapter struct {
-	*log.Logger
-}
-
-func (l logAdapter) Log(
-	_ context.Context,
-	level pgmigrate.LogLevel,
-	msg string,
-	fields ...pgmigrate.LogField,
-) {
-	args := make([]any, 0, 2*len(fields))
-	for _, field := range fields {
-		args = append(args, field.Key, field.Value)
-	}
-	switch level {
-	case pgmigrate.LogLevelDebug:
-		l.Logger.Debug(msg, args...)
-	case pgmigrate.LogLevelInfo:
-		l.Logger.Info(msg, args...)
-	case pgmigrate.LogLevelError:
-		l.Logger.Error(msg, args...)
-	case pgmigrate.LogLevelWarning:
-		l.Logger.Warn(msg, args...)
-	}
-}
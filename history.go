@@ -0,0 +1,301 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+	"github.com/peterldowns/pgmigrate/internal/sessionlock"
+)
+
+// DefaultHistoryTableName is the conventional name of the history table (with
+// schema), see [Migrator.HistoryTableName].
+const DefaultHistoryTableName string = "public.pgmigrate_history"
+
+// HistoryAction is the kind of change that a [HistoryEntry] records.
+type HistoryAction string
+
+const (
+	// HistoryApply is an attempt to apply a migration with
+	// [Migrator.Migrate].
+	HistoryApply HistoryAction = "apply"
+	// HistoryMarkApplied is a migration that was marked as applied with
+	// [Migrator.MarkApplied].
+	HistoryMarkApplied HistoryAction = "mark-applied"
+	// HistoryMarkUnapplied is a migration that was marked as unapplied with
+	// [Migrator.MarkUnapplied].
+	HistoryMarkUnapplied HistoryAction = "mark-unapplied"
+	// HistorySetChecksum is a change to the recorded checksum of a migration
+	// with [Migrator.SetChecksums].
+	HistorySetChecksum HistoryAction = "set-checksum"
+)
+
+// HistoryOutcome is how a [HistoryEntry]'s action turned out.
+type HistoryOutcome string
+
+const (
+	// HistorySucceeded means that the change was made.
+	HistorySucceeded HistoryOutcome = "succeeded"
+	// HistoryFailed means that the migration failed to apply.
+	HistoryFailed HistoryOutcome = "failed"
+	// HistoryRolledBack means that the migration was applied successfully,
+	// but then rolled back because another migration in the same transaction
+	// failed, see [Migrator.SingleTransaction].
+	HistoryRolledBack HistoryOutcome = "rolled-back"
+)
+
+// HistoryEntry is a row in the history table, see [Migrator.HistoryTableName].
+type HistoryEntry struct {
+	// Seq orders the entries in the order that they were recorded.
+	Seq int64
+	// MigrationID is the ID of the migration that the entry is about.
+	MigrationID string
+	Action      HistoryAction
+	Outcome     HistoryOutcome
+	// StartedAt is when the action started.
+	StartedAt time.Time
+	// ExecutionTimeInMillis is how long it took to apply the migration, or to
+	// fail. It is 0 for actions other than [HistoryApply].
+	ExecutionTimeInMillis int64
+	// ErrorCode is the SQLSTATE of the error that a failed migration returned,
+	// if the error came from Postgres.
+	ErrorCode string
+	// ErrorMessage is the error that a failed migration returned.
+	ErrorMessage string
+	// ChecksumBefore and ChecksumAfter are the migration's recorded checksum
+	// before and after the action, and are empty when there was no record.
+	ChecksumBefore string
+	ChecksumAfter  string
+	// Actor is the Postgres user (current_user) that made the change.
+	Actor string
+	// Hostname, ApplicationName, and PgmigrateVersion are the hostname of
+	// the machine that made the change, the application_name of its
+	// connection, and the version of pgmigrate that it was running.
+	Hostname         string
+	ApplicationName  string
+	PgmigrateVersion string
+}
+
+// HistoryFilter selects the entries returned by [Migrator.History]. Each
+// field that is set narrows down the entries; the zero value selects all of
+// them.
+type HistoryFilter struct {
+	// MigrationIDs, if set, selects the entries about any of these
+	// migrations.
+	MigrationIDs []string
+	// Since and Until, if set, select the entries that started at or after
+	// Since and before Until.
+	Since time.Time
+	Until time.Time
+	// Action and Outcome, if set, select the entries with this action and
+	// outcome.
+	Action  HistoryAction
+	Outcome HistoryOutcome
+	// Limit, if positive, returns only the most recent Limit entries.
+	Limit int
+}
+
+// History returns the entries in the history table that match the filter, in
+// the order that they were recorded. If the history table does not exist, it
+// returns no entries.
+func (m *Migrator) History(ctx context.Context, db Querier, filter HistoryFilter) ([]HistoryEntry, error) {
+	if m.HistoryTableName == "" {
+		return nil, fmt.Errorf("no history table: set HistoryTableName")
+	}
+	var exists bool
+	query := `SELECT to_regclass($1) IS NOT NULL`
+	if err := m.queryRowContext(ctx, db, query, pgtools.Identifier(m.HistoryTableName)).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if len(filter.MigrationIDs) != 0 {
+		where("migration_id = any($%d)", filter.MigrationIDs)
+	}
+	if !filter.Since.IsZero() {
+		where("started_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("started_at < $%d", filter.Until)
+	}
+	if filter.Action != "" {
+		where("action = $%d", string(filter.Action))
+	}
+	if filter.Outcome != "" {
+		where("outcome = $%d", string(filter.Outcome))
+	}
+	query = fmt.Sprintf(`
+		SELECT
+			seq, migration_id, action, outcome, started_at, execution_time_in_millis,
+			coalesce(error_code, ''), coalesce(error_message, ''),
+			coalesce(checksum_before, ''), coalesce(checksum_after, ''),
+			actor, coalesce(hostname, ''), coalesce(application_name, ''),
+			coalesce(pgmigrate_version, '')
+		FROM %s`, pgtools.Identifier(m.HistoryTableName))
+	if len(conditions) != 0 {
+		query += "\nWHERE " + strings.Join(conditions, " AND ")
+	}
+	query += "\nORDER BY seq DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf("\nLIMIT %d", filter.Limit)
+	}
+	rows, err := m.queryContext(ctx, db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer rows.Close()
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(
+			&entry.Seq, &entry.MigrationID, &entry.Action, &entry.Outcome,
+			&entry.StartedAt, &entry.ExecutionTimeInMillis,
+			&entry.ErrorCode, &entry.ErrorMessage,
+			&entry.ChecksumBefore, &entry.ChecksumAfter,
+			&entry.Actor, &entry.Hostname, &entry.ApplicationName,
+			&entry.PgmigrateVersion,
+		); err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	// The newest entries were selected so that the limit keeps the most
+	// recent ones, but they are returned oldest first.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// ensureHistoryTable creates the history table if it does not exist. It is
+// created while holding a transaction-level advisory lock, so that migrators
+// and ops that record history at the same time do not race to create it.
+func (m *Migrator) ensureHistoryTable(ctx context.Context, db Querier) error {
+	if m.HistoryTableName == "" {
+		return nil
+	}
+	return m.inTx(ctx, db, func(tx *sql.Tx) error {
+		query := `SELECT pg_advisory_xact_lock($1)`
+		if _, err := m.execContext(ctx, tx, query, sessionlock.ID(m.HistoryTableName)); err != nil {
+			return fmt.Errorf("ensureHistoryTable/lock: %w", err)
+		}
+		schema, _ := pgtools.ParseTableName(m.HistoryTableName)
+		query = fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, pgtools.Identifier(schema))
+		if _, err := m.execContext(ctx, tx, query); err != nil {
+			return fmt.Errorf("ensureHistoryTable/create schema: %w", err)
+		}
+		query = fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				seq BIGSERIAL PRIMARY KEY,
+				migration_id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				outcome TEXT NOT NULL,
+				started_at TIMESTAMPTZ NOT NULL,
+				execution_time_in_millis BIGINT NOT NULL,
+				error_code TEXT,
+				error_message TEXT,
+				checksum_before TEXT,
+				checksum_after TEXT,
+				actor TEXT NOT NULL,
+				hostname TEXT,
+				application_name TEXT,
+				pgmigrate_version TEXT
+			)
+		`, pgtools.Identifier(m.HistoryTableName))
+		if _, err := m.execContext(ctx, tx, query); err != nil {
+			return fmt.Errorf("ensureHistoryTable: %w", err)
+		}
+		return nil
+	})
+}
+
+// insertHistory appends an entry to the history table, filling in the details
+// of who made the change.
+func (m *Migrator) insertHistory(ctx context.Context, db queryer, entry HistoryEntry) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+		( migration_id, action, outcome, started_at, execution_time_in_millis,
+		  error_code, error_message, checksum_before, checksum_after,
+		  actor, hostname, application_name, pgmigrate_version )
+		VALUES
+		( $1, $2, $3, $4, $5,
+		  $6, $7, $8, $9,
+		  current_user, $10, current_setting('application_name'), $11 )`,
+		pgtools.Identifier(m.HistoryTableName),
+	)
+	_, err := m.execContext(ctx, db, query,
+		entry.MigrationID, entry.Action, entry.Outcome, entry.StartedAt, entry.ExecutionTimeInMillis,
+		nullString(entry.ErrorCode), nullString(entry.ErrorMessage),
+		nullString(entry.ChecksumBefore), nullString(entry.ChecksumAfter),
+		nullString(hostname()), pgmigrateVersion(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record history: %w", err)
+	}
+	return nil
+}
+
+// recordAttempt records the outcome of an attempt to apply a migration in the
+// history table, if there is one. It runs outside of the migration's
+// transaction, so that failed attempts are recorded too. Failing to record
+// the attempt is logged, but does not change the outcome of the migration.
+func (m *Migrator) recordAttempt(
+	ctx context.Context,
+	db queryer,
+	migration Migration,
+	outcome HistoryOutcome,
+	startedAt time.Time,
+	executionTimeMs int64,
+	err error,
+) {
+	if m.HistoryTableName == "" {
+		return
+	}
+	entry := HistoryEntry{
+		MigrationID:           migration.ID,
+		Action:                HistoryApply,
+		Outcome:               outcome,
+		StartedAt:             startedAt,
+		ExecutionTimeInMillis: executionTimeMs,
+	}
+	if outcome == HistorySucceeded {
+		entry.ChecksumAfter = m.Checksum(migration)
+	}
+	if err != nil {
+		entry.ErrorCode = pgtools.ErrorCode(err)
+		entry.ErrorMessage = err.Error()
+	}
+	// Record the attempt even if it failed because ctx was canceled.
+	if herr := m.insertHistory(context.WithoutCancel(ctx), db, entry); herr != nil {
+		m.error(ctx, herr, "failed to record history", LogField{Key: "migration_id", Value: migration.ID})
+	}
+}
+
+// recordOp records a change made by one of the ops methods in the history
+// table, if there is one, as part of the same transaction as the change.
+func (m *Migrator) recordOp(ctx context.Context, tx *sql.Tx, action HistoryAction, id, before, after string) error {
+	if m.HistoryTableName == "" {
+		return nil
+	}
+	return m.insertHistory(ctx, tx, HistoryEntry{
+		MigrationID:    id,
+		Action:         action,
+		Outcome:        HistorySucceeded,
+		StartedAt:      time.Now().UTC(),
+		ChecksumBefore: before,
+		ChecksumAfter:  after,
+	})
+}
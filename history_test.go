@@ -0,0 +1,165 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestHistoryRecordsAttempts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_broken", SQL: "SELECT * FROM does_not_exist;"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.HistoryTableName = pgmigrate.DefaultHistoryTableName
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		entries, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(entries))
+
+		check.Equal(t, "0001_users", entries[0].MigrationID)
+		check.Equal(t, pgmigrate.HistoryApply, entries[0].Action)
+		check.Equal(t, pgmigrate.HistorySucceeded, entries[0].Outcome)
+		check.Equal(t, migrator.Checksum(migrations[0]), entries[0].ChecksumAfter)
+		check.Equal(t, "", entries[0].ErrorCode)
+		check.NotEqual(t, "", entries[0].Actor)
+
+		// The failed attempt is recorded even though its transaction was
+		// rolled back.
+		check.Equal(t, "0002_broken", entries[1].MigrationID)
+		check.Equal(t, pgmigrate.HistoryFailed, entries[1].Outcome)
+		check.Equal(t, "42P01", entries[1].ErrorCode) // undefined_table
+		check.NotEqual(t, "", entries[1].ErrorMessage)
+		check.Equal(t, "", entries[1].ChecksumAfter)
+		check.True(t, entries[0].Seq < entries[1].Seq)
+
+		failed, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{Outcome: pgmigrate.HistoryFailed})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(failed))
+		check.Equal(t, "0002_broken", failed[0].MigrationID)
+
+		byID, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{MigrationIDs: []string{"0001_users"}})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(byID))
+		check.Equal(t, "0001_users", byID[0].MigrationID)
+
+		latest, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{Limit: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(latest))
+		check.Equal(t, "0002_broken", latest[0].MigrationID)
+
+		future, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{Since: time.Now().Add(time.Hour)})
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(future))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestHistoryRecordsRolledBackAttempts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_broken", SQL: "SELECT * FROM does_not_exist;"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.HistoryTableName = pgmigrate.DefaultHistoryTableName
+		migrator.SingleTransaction = true
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		entries, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(entries))
+		check.Equal(t, "0001_users", entries[0].MigrationID)
+		check.Equal(t, pgmigrate.HistoryRolledBack, entries[0].Outcome)
+		check.Equal(t, "0002_broken", entries[1].MigrationID)
+		check.Equal(t, pgmigrate.HistoryFailed, entries[1].Outcome)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestHistoryRecordsOps(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.HistoryTableName = "audit.history"
+		checksum := migrator.Checksum(migrations[0])
+
+		_, err := migrator.MarkApplied(ctx, db, "0001_users")
+		assert.Nil(t, err)
+		_, err = migrator.SetChecksums(ctx, db, pgmigrate.ChecksumUpdate{MigrationID: "0001_users", NewChecksum: "changed"})
+		assert.Nil(t, err)
+		_, err = migrator.MarkUnapplied(ctx, db, "0001_users")
+		assert.Nil(t, err)
+
+		entries, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(entries))
+		check.Equal(t, pgmigrate.HistoryMarkApplied, entries[0].Action)
+		check.Equal(t, "", entries[0].ChecksumBefore)
+		check.Equal(t, checksum, entries[0].ChecksumAfter)
+		check.Equal(t, pgmigrate.HistorySetChecksum, entries[1].Action)
+		check.Equal(t, checksum, entries[1].ChecksumBefore)
+		check.Equal(t, "changed", entries[1].ChecksumAfter)
+		check.Equal(t, pgmigrate.HistoryMarkUnapplied, entries[2].Action)
+		check.Equal(t, "changed", entries[2].ChecksumBefore)
+		check.Equal(t, "", entries[2].ChecksumAfter)
+		for _, entry := range entries {
+			check.Equal(t, "0001_users", entry.MigrationID)
+			check.Equal(t, pgmigrate.HistorySucceeded, entry.Outcome)
+		}
+
+		setChecksums, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{Action: pgmigrate.HistorySetChecksum})
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(setChecksums))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestHistoryWithoutTable(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator(nil)
+		migrator.Logger = logger
+		_, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{})
+		check.Error(t, err)
+
+		// A history table that was never created has no entries.
+		migrator.HistoryTableName = pgmigrate.DefaultHistoryTableName
+		entries, err := migrator.History(ctx, db, pgmigrate.HistoryFilter{})
+		check.Nil(t, err)
+		check.Equal(t, 0, len(entries))
+		return nil
+	})
+	assert.Nil(t, err)
+}
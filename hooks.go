@@ -0,0 +1,50 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Hooks are optional callbacks that a [Migrator] calls while it applies
+// migrations, so that you can run your own code around them. Any of the hooks
+// may be nil.
+//
+// An error returned from a hook aborts the run exactly like a failed
+// migration: the current migration's transaction (if any) is rolled back, no
+// further migrations are attempted, and the error is returned from
+// [Migrator.Migrate].
+type Hooks struct {
+	// BeforeMigrate is called once the advisory lock has been acquired and the
+	// plan has been calculated, before any migrations are applied. It is
+	// called even if the plan is empty.
+	BeforeMigrate func(ctx context.Context, plan []Migration) error
+	// BeforeEach is called before each migration is applied, inside of the
+	// same transaction as the migration, so that any SQL it runs is atomic
+	// with the migration. It is called again for each retry of a migration.
+	//
+	// For [Migration.NoTransaction] migrations, tx is nil.
+	BeforeEach func(ctx context.Context, tx *sql.Tx, migration Migration) error
+	// AfterEach is called after each migration. If the migration succeeded,
+	// it is called with the [AppliedMigration] and a nil error, inside of the
+	// same transaction as the migration, before it is committed.
+	//
+	// If the migration failed, it is called once after the transaction has
+	// been rolled back (and after any retries) with a nil tx and the error.
+	// Only the Migration field of the AppliedMigration is set. The migration
+	// error is returned from [Migrator.Migrate] regardless of what the hook
+	// returns. It is not called a second time if it was the AfterEach hook
+	// itself that caused the migration to fail.
+	//
+	// If [Migrator.SingleTransaction] is set, the failure rolls back every
+	// migration in the plan, but the hook is only called for the migration
+	// that failed.
+	//
+	// For [Migration.NoTransaction] migrations, tx is always nil and the hook
+	// is called after the migration has been marked as applied, so an error
+	// from the hook aborts the run but does not undo the migration.
+	AfterEach func(ctx context.Context, tx *sql.Tx, applied AppliedMigration, err error) error
+	// AfterMigrate is called once at the end of the run, before the advisory
+	// lock is released, with the migrations that were applied and the error
+	// that the run is about to return, if any.
+	AfterMigrate func(ctx context.Context, applied []AppliedMigration, err error) error
+}
@@ -0,0 +1,201 @@
+// diff computes line-based differences between two texts and formats them as
+// unified diffs, like `diff -u`. It is designed for internal use only.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+// Unified returns a unified diff that turns a into b, using the given names in
+// the `---` and `+++` header lines. It returns an empty string if a and b are
+// identical.
+func Unified(aName, bName, a, b string) string {
+	aLines, bLines := splitLines(a), splitLines(b)
+	ops := edits(aLines, bLines)
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		// Find the next change.
+		for i < len(ops) && ops[i].kind == equal {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := max(i-Context, 0)
+		// Extend the hunk to include any changes that are close enough that
+		// their context would overlap.
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != equal {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == equal {
+				next++
+			}
+			if next == len(ops) || next-end > 2*Context {
+				break
+			}
+			end = next
+		}
+		stop := min(end+Context, len(ops))
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+		writeHunk(&out, ops[start:stop], aLines, bLines)
+		i = stop
+	}
+	return out.String()
+}
+
+// writeHunk writes a single hunk, with its `@@` header line.
+func writeHunk(out *strings.Builder, ops []edit, a, b []string) {
+	var aCount, bCount int
+	for _, op := range ops {
+		if op.kind != insert {
+			aCount++
+		}
+		if op.kind != remove {
+			bCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(ops[0].a, aCount), hunkRange(ops[0].b, bCount))
+	for _, op := range ops {
+		switch op.kind {
+		case equal:
+			writeLine(out, ' ', a[op.a])
+		case remove:
+			writeLine(out, '-', a[op.a])
+		case insert:
+			writeLine(out, '+', b[op.b])
+		}
+	}
+}
+
+// hunkRange formats the range of lines in a hunk header. By convention, an
+// empty range starts at the line before the hunk.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(out *strings.Builder, prefix byte, line string) {
+	out.WriteByte(prefix)
+	out.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// splitLines splits text into lines, each of which keeps its trailing newline
+// (except possibly the last one).
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type kind int
+
+const (
+	equal kind = iota
+	remove
+	insert
+)
+
+// edit is a single step in turning a into b. a and b are the indexes of the
+// current line in each text.
+type edit struct {
+	kind kind
+	a, b int
+}
+
+// edits returns the shortest list of edits that turns a into b, using Myers'
+// O(ND) difference algorithm.
+func edits(a, b []string) []edit {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit == 0 {
+		return nil
+	}
+	// v[offset+k] is the furthest x reached on diagonal k = x - y. trace[d]
+	// is a copy of the diagonals -d..d of v from before step d, which is
+	// enough to walk back through the path once it reaches the end.
+	offset := limit
+	v := make([]int, 2*limit+2)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+// backtrack walks back through the trace from (n, m) to (0, 0), returning the
+// edits in order.
+func backtrack(trace [][]int, n, m int) []edit {
+	var result []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		var prevX int
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			result = append(result, edit{kind: equal, a: x, b: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				result = append(result, edit{kind: insert, a: x, b: y})
+			} else {
+				x--
+				result = append(result, edit{kind: remove, a: x, b: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
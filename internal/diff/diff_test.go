@@ -0,0 +1,80 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "identical",
+			a:    "SELECT 1;\n",
+			b:    "SELECT 1;\n",
+			want: "",
+		},
+		{
+			name: "both empty",
+			a:    "",
+			b:    "",
+			want: "",
+		},
+		{
+			name: "changed line",
+			a:    "CREATE TABLE cats ();\n",
+			b:    "CREATE TABLE dogs ();\n",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-CREATE TABLE cats ();\n+CREATE TABLE dogs ();\n",
+		},
+		{
+			name: "added to empty",
+			a:    "",
+			b:    "one\ntwo\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+one\n+two\n",
+		},
+		{
+			name: "removed everything",
+			a:    "one\ntwo\n",
+			b:    "",
+			want: "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-one\n-two\n",
+		},
+		{
+			name: "context is limited to three lines",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: "--- a\n+++ b\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "missing trailing newline",
+			a:    "SELECT 1;\n",
+			b:    "SELECT 1;",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-SELECT 1;\n+SELECT 1;\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			check.Equal(t, tc.want, diff.Unified("a", "b", tc.a, tc.b))
+		})
+	}
+}
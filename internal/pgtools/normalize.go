@@ -0,0 +1,36 @@
+package pgtools
+
+import "strings"
+
+// NormalizeSQL returns a canonical form of a string of SQL that ignores
+// differences that do not change its meaning. Comments are removed, and each
+// run of whitespace outside of a quoted string, a quoted identifier, or a
+// dollar-quoted string is replaced by a single space, with none at the start
+// or the end. Quoted sections are kept as-is, except that their `\r\n` line
+// endings are replaced by `\n`.
+func NormalizeSQL(sql string) string {
+	var out strings.Builder
+	s := scanner{src: sql}
+	space := false
+	for !s.done() {
+		if s.skipComment() {
+			space = true
+			continue
+		}
+		if isSpace(s.peek()) {
+			space = true
+			s.pos++
+			continue
+		}
+		if space && out.Len() != 0 {
+			out.WriteByte(' ')
+		}
+		space = false
+		start := s.pos
+		if !s.skipQuoted() {
+			s.pos++
+		}
+		out.WriteString(strings.ReplaceAll(sql[start:s.pos], "\r\n", "\n"))
+	}
+	return out.String()
+}
@@ -0,0 +1,48 @@
+package pgtools_test
+
+import (
+	"testing"
+
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    ``,
+			expected: ``,
+		},
+		{
+			input:    `-- just a comment`,
+			expected: ``,
+		},
+		{
+			// whitespace is collapsed and trimmed
+			input:    "\n  select   1;\r\n\r\n\tselect 2;  \n",
+			expected: `select 1; select 2;`,
+		},
+		{
+			// comments are removed
+			input:    "-- header\nselect 1 /* a /* nested */ comment */ + 1; -- trailing\nselect/**/2;",
+			expected: `select 1 + 1; select 2;`,
+		},
+		{
+			// quoted sections are kept as-is
+			input:    "select '  a  -- b  ', \"c  d\", $$ e  /* f */ $$;",
+			expected: "select '  a  -- b  ', \"c  d\", $$ e  /* f */ $$;",
+		},
+		{
+			// except for their line endings
+			input:    "select 'a\r\nb';",
+			expected: "select 'a\nb';",
+		},
+	} {
+		check.Equal(t, tc.expected, pgtools.NormalizeSQL(tc.input))
+	}
+}
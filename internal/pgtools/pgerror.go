@@ -55,13 +55,39 @@ func (pe *Error) Error() string {
 	return pe.Severity + ": " + pe.Message + " (SQLSTATE " + pe.Code + ")"
 }
 
+// LockNotAvailable is the SQLSTATE code for a `lock_not_available` error, which
+// is what postgres returns when a statement exceeds its `lock_timeout`.
+const LockNotAvailable = "55P03"
+
+// sqlStater is implemented by the errors returned by most postgres drivers,
+// including pgx and lib/pq.
+type sqlStater interface {
+	SQLState() string
+}
+
+// ErrorCode returns the SQLSTATE code of an error that came from postgres, or
+// an empty string if the error did not come from postgres.
+func ErrorCode(err error) string {
+	var perr *Error
+	if errors.As(err, &perr) {
+		return perr.Code
+	}
+	var serr sqlStater
+	if errors.As(err, &serr) {
+		return serr.SQLState()
+	}
+	return ""
+}
+
 // If an error comes from postgres, return as much information as possible for
 // logging purposes.
 func ErrorData(err error) map[string]any {
 	data := make(map[string]any)
+	if code := ErrorCode(err); code != "" {
+		data["pg_code"] = code
+	}
 	var perr *Error
 	if errors.As(err, &perr) {
-		data["pg_code"] = perr.Code
 		if perr.Detail != "" {
 			data["pg_detail"] = perr.Detail
 		}
@@ -0,0 +1,219 @@
+package pgtools
+
+import "strings"
+
+// SplitStatements splits a string containing multiple SQL statements into the
+// individual statements, in order. Statements are separated by semicolons that
+// are not part of a comment, a quoted string, a quoted identifier, a
+// dollar-quoted string, or a `BEGIN ATOMIC ... END` function body. The returned statements do not include their trailing
+// semicolons or any leading whitespace and comments, and any statement
+// consisting only of whitespace and comments is omitted.
+//
+// This is a small lexer rather than a full parser; it understands enough of
+// the Postgres grammar to find statement boundaries and nothing else.
+func SplitStatements(sql string) []string {
+	var statements []string
+	start := 0
+	hasCode := false
+	// depth counts the `BEGIN ATOMIC` bodies and the `CASE` expressions
+	// inside of them that have not been closed by an `END` yet.
+	depth := 0
+	previous := ""
+	s := scanner{src: sql}
+	for !s.done() {
+		switch {
+		case s.skipComment():
+			continue
+		case s.peek() == ';' && depth == 0:
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(sql[start:s.pos]))
+			}
+			s.pos++
+			start = s.pos
+			hasCode = false
+			previous = ""
+			continue
+		}
+		if !hasCode && !isSpace(s.peek()) {
+			start = s.pos
+			hasCode = true
+		}
+		if word := strings.ToLower(s.word()); word != "" {
+			switch {
+			case word == "atomic" && previous == "begin":
+				depth++
+			case word == "case" && depth > 0:
+				depth++
+			case word == "end" && depth > 0:
+				depth--
+			}
+			previous = word
+			continue
+		}
+		if !s.skipQuoted() {
+			s.pos++
+		}
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(sql[start:]))
+	}
+	return statements
+}
+
+// scanner walks a string of SQL, providing helpers for skipping over comments
+// and quoted sections.
+type scanner struct {
+	src string
+	pos int
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *scanner) peek() byte {
+	return s.src[s.pos]
+}
+
+func (s *scanner) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(s.src[s.pos:], prefix)
+}
+
+// word advances past the keyword or identifier that starts at the current
+// position, if there is one, and returns it.
+func (s *scanner) word() string {
+	start := s.pos
+	for !s.done() && isIdentifierChar(s.peek()) {
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
+
+// skipComment advances past a `-- line comment` or a `/* block comment */`
+// (which may be nested) if one starts at the current position, and reports
+// whether it did so.
+func (s *scanner) skipComment() bool {
+	switch {
+	case s.hasPrefix("--"):
+		end := strings.IndexByte(s.src[s.pos:], '\n')
+		if end == -1 {
+			s.pos = len(s.src)
+		} else {
+			s.pos += end
+		}
+		return true
+	case s.hasPrefix("/*"):
+		depth := 0
+		for !s.done() {
+			switch {
+			case s.hasPrefix("/*"):
+				depth++
+				s.pos += 2
+			case s.hasPrefix("*/"):
+				depth--
+				s.pos += 2
+				if depth == 0 {
+					return true
+				}
+			default:
+				s.pos++
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// skipQuoted advances past a 'string', an E'escaped string', a "quoted
+// identifier", or a $tag$dollar-quoted string$tag$ if one starts at the
+// current position, and reports whether it did so.
+func (s *scanner) skipQuoted() bool {
+	c := s.peek()
+	switch {
+	case c == '\'':
+		s.skipDelimited('\'', s.isEscapeString())
+		return true
+	case c == '"':
+		s.skipDelimited('"', false)
+		return true
+	case c == '$':
+		tag, ok := s.dollarTag()
+		if !ok {
+			return false
+		}
+		s.pos += len(tag)
+		end := strings.Index(s.src[s.pos:], tag)
+		if end == -1 {
+			s.pos = len(s.src)
+		} else {
+			s.pos += end + len(tag)
+		}
+		return true
+	}
+	return false
+}
+
+// isEscapeString reports whether the quote at the current position starts an
+// E'escaped string', which is only the case if the `E` before it is a token of
+// its own. The quote in a typed literal like `date'2020-01-01'` starts a
+// standard string, where a backslash is just a backslash.
+func (s *scanner) isEscapeString() bool {
+	if s.pos == 0 || (s.src[s.pos-1] != 'E' && s.src[s.pos-1] != 'e') {
+		return false
+	}
+	return s.pos == 1 || !isIdentifierChar(s.src[s.pos-2])
+}
+
+// skipDelimited advances past a section that starts and ends with the given
+// delimiter, where a doubled delimiter is an escaped delimiter. If backslashes
+// is true, a backslash also escapes the following character.
+func (s *scanner) skipDelimited(delim byte, backslashes bool) {
+	s.pos++ // opening delimiter
+	for !s.done() {
+		c := s.peek()
+		switch {
+		case backslashes && c == '\\':
+			s.pos += 2
+		case c == delim && s.pos+1 < len(s.src) && s.src[s.pos+1] == delim:
+			s.pos += 2
+		case c == delim:
+			s.pos++
+			return
+		default:
+			s.pos++
+		}
+	}
+	if s.pos > len(s.src) {
+		s.pos = len(s.src)
+	}
+}
+
+// dollarTag returns the `$tag$` that starts at the current position, if there
+// is one. A `$` that is part of an identifier or a positional parameter like
+// `$1` does not start a dollar-quoted string.
+func (s *scanner) dollarTag() (string, bool) {
+	if s.pos > 0 && isIdentifierChar(s.src[s.pos-1]) {
+		return "", false
+	}
+	for i := s.pos + 1; i < len(s.src); i++ {
+		c := s.src[i]
+		if c == '$' {
+			return s.src[s.pos : i+1], true
+		}
+		if !isIdentifierChar(c) || (i == s.pos+1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
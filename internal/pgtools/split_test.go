@@ -0,0 +1,91 @@
+package pgtools_test
+
+import (
+	"testing"
+
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    ``,
+			expected: nil,
+		},
+		{
+			input:    `-- just a comment`,
+			expected: nil,
+		},
+		{
+			input:    `select 1`,
+			expected: []string{`select 1`},
+		},
+		{
+			input:    "select 1;\nselect 2;\n",
+			expected: []string{`select 1`, `select 2`},
+		},
+		{
+			// empty statements and comment-only statements are dropped
+			input:    "select 1;;\n-- a comment;\n;select 2",
+			expected: []string{`select 1`, `select 2`},
+		},
+		{
+			// semicolons inside of comments do not split
+			input:    "select 1 /* a; /* nested; */ comment */ + 1; -- trailing;\nselect 2",
+			expected: []string{`select 1 /* a; /* nested; */ comment */ + 1`, `select 2`},
+		},
+		{
+			// semicolons inside of strings and identifiers do not split
+			input:    `select 'a;''b', E'c\';d', "e;""f"; select 2`,
+			expected: []string{`select 'a;''b', E'c\';d', "e;""f"`, `select 2`},
+		},
+		{
+			// a backslash escapes the next character in an escape string
+			input:    `E'x\''; select 1;`,
+			expected: []string{`E'x\''`, `select 1`},
+		},
+		{
+			// a typed literal is a standard string, even if the type ends in "e"
+			input:    `select date'x\'; select 1;`,
+			expected: []string{`select date'x\'`, `select 1`},
+		},
+		{
+			// semicolons inside of dollar-quoted strings do not split
+			input: "create function f() returns int as $$ select 1; $$ language sql;\n" +
+				"create function g() returns int as $body$ select $1; $$ $body$ language sql;",
+			expected: []string{
+				`create function f() returns int as $$ select 1; $$ language sql`,
+				`create function g() returns int as $body$ select $1; $$ $body$ language sql`,
+			},
+		},
+		{
+			// semicolons inside of SQL-standard function bodies do not split,
+			// including after a CASE expression's END
+			input: "CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT CASE WHEN true THEN 2 END; END;\n" +
+				"select case when true then 3 end; select 4",
+			expected: []string{
+				`CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT CASE WHEN true THEN 2 END; END`,
+				`select case when true then 3 end`,
+				`select 4`,
+			},
+		},
+		{
+			// a transaction's BEGIN is not a function body
+			input:    `begin; select 1; end; select 2`,
+			expected: []string{`begin`, `select 1`, `end`, `select 2`},
+		},
+		{
+			// positional parameters are not dollar-quoted strings
+			input:    `select $1; select 2`,
+			expected: []string{`select $1`, `select 2`},
+		},
+	} {
+		check.Equal(t, tc.expected, pgtools.SplitStatements(tc.input))
+	}
+}
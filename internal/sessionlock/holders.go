@@ -0,0 +1,116 @@
+package sessionlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Queryer is satisfied by *sql.DB, *sql.Conn, and *sql.Tx.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Holder describes the holder of a lock. For a [Session] lock, this is the
+// session that holds the advisory lock, as reported by `pg_stat_activity`.
+// Postgres only shows some of these details for sessions of other users to
+// superusers and members of `pg_read_all_stats`; the details that are hidden
+// are left empty. For a [Lease] lock, only Owner, AcquiredAt, and ExpiresAt
+// are set.
+type Holder struct {
+	// PID is the process ID of the backend serving the session.
+	PID int
+	// Username is the Postgres user of the session.
+	Username string
+	// ApplicationName is the `application_name` of the session.
+	ApplicationName string
+	// ClientAddr is the address of the client, or empty if the client is
+	// connected through a Unix socket.
+	ClientAddr string
+	// QueryStart is when the session's current or most recent query started.
+	QueryStart time.Time
+	// State is the state of the session, like "active" or "idle".
+	State string
+	// Owner identifies the holder of a [Lease].
+	Owner string
+	// AcquiredAt is when the [Lease] was acquired.
+	AcquiredAt time.Time
+	// ExpiresAt is when the [Lease] expires unless its holder renews it.
+	ExpiresAt time.Time
+}
+
+// holdersQuery finds the sessions holding the advisory lock with a given ID in
+// the current database. An advisory lock on a single bigint key is shown in
+// `pg_locks` with the high half of the key as classid, the low half as objid,
+// and objsubid = 1.
+const holdersQuery = `
+SELECT
+	a.pid,
+	coalesce(a.usename, ''),
+	coalesce(a.application_name, ''),
+	coalesce(host(a.client_addr), ''),
+	a.query_start,
+	coalesce(a.state, '')
+FROM pg_locks l
+JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory'
+	AND l.granted
+	AND l.objsubid = 1
+	AND (l.classid::bigint << 32 | l.objid::bigint) = $1
+	AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+ORDER BY a.pid
+`
+
+// Holders returns the sessions that currently hold the lock, which is normally
+// either none or exactly one.
+func Holders(ctx context.Context, db Queryer, lockName string) ([]Holder, error) {
+	rows, err := db.QueryContext(ctx, holdersQuery, ID(lockName))
+	if err != nil {
+		return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+	}
+	defer rows.Close()
+	var holders []Holder
+	for rows.Next() {
+		var holder Holder
+		var queryStart sql.NullTime
+		if err := rows.Scan(
+			&holder.PID,
+			&holder.Username,
+			&holder.ApplicationName,
+			&holder.ClientAddr,
+			&queryStart,
+			&holder.State,
+		); err != nil {
+			return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+		}
+		holder.QueryStart = queryStart.Time
+		holders = append(holders, holder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+	}
+	return holders, nil
+}
+
+// Terminate ends the backend with the given PID with `pg_terminate_backend`,
+// but only if it still holds the lock. It returns false if that session no
+// longer holds the lock.
+func Terminate(ctx context.Context, db Queryer, lockName string, pid int) (bool, error) {
+	holders, err := Holders(ctx, db, lockName)
+	if err != nil {
+		return false, err
+	}
+	for _, holder := range holders {
+		if holder.PID != pid {
+			continue
+		}
+		var terminated bool
+		if err := db.QueryRowContext(ctx, "SELECT pg_terminate_backend($1)", pid).Scan(&terminated); err != nil {
+			return false, fmt.Errorf("sessionlock(%s) failed to terminate %d: %w", lockName, pid, err)
+		}
+		return terminated, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,232 @@
+package sessionlock
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/peterldowns/pgmigrate/internal/multierr"
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+// DefaultLeaseTTL is how long a [Lease] lasts unless its holder renews it.
+const DefaultLeaseTTL time.Duration = 30 * time.Second
+
+// ErrLeaseLost is the cause of the cancellation of the context given to the
+// callback of [Lease.With] if the lease could not be renewed before it
+// expired, after which another caller may acquire it.
+var ErrLeaseLost = errors.New("lease was lost")
+
+// Lease is a [Locker] that acquires a lock by inserting a row into a lock
+// table, and holds it by renewing the row's expiry with a heartbeat. Every
+// query runs in its own transaction, so unlike a [Session] lock, a lease works
+// through any connection pooler, including PgBouncer in transaction pooling
+// mode. If the holder crashes, its lease expires after the TTL and another
+// caller can acquire it.
+type Lease struct {
+	// TableName is the table that holds the leases, which is created if it
+	// does not exist.
+	TableName string
+	// TTL is how long a lease lasts without being renewed. The lease is
+	// renewed every third of the TTL. If it is zero, [DefaultLeaseTTL] is
+	// used.
+	TTL time.Duration
+	// Owner identifies the holder of the lease in the lock table. If it is
+	// empty, a unique owner is generated from the hostname and process ID.
+	Owner string
+}
+
+func (l Lease) With(ctx context.Context, db *sql.DB, lockName string, opts Options, cb func(context.Context, *sql.Conn) error) (final error) {
+	ttl := l.TTL
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	owner := l.Owner
+	if owner == "" {
+		owner = defaultOwner()
+	}
+	if err := l.ensureTable(ctx, db); err != nil {
+		return fmt.Errorf("sessionlock(%s) failed to create lease table: %w", lockName, err)
+	}
+	table := pgtools.Identifier(l.TableName)
+	// An expired lease is taken over by the next caller, without waiting for
+	// its holder to release it.
+	acquireQuery := fmt.Sprintf(`
+		INSERT INTO %[1]s (name, owner, acquired_at, renewed_at, expires_at)
+		VALUES ($1, $2, now(), now(), now() + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET
+			owner = excluded.owner,
+			acquired_at = excluded.acquired_at,
+			renewed_at = excluded.renewed_at,
+			expires_at = excluded.expires_at
+		WHERE %[1]s.expires_at < now()
+	`, table)
+	renewQuery := fmt.Sprintf(`
+		UPDATE %s SET renewed_at = now(), expires_at = now() + $3 * interval '1 millisecond'
+		WHERE name = $1 AND owner = $2
+	`, table)
+	releaseQuery := fmt.Sprintf(`DELETE FROM %s WHERE name = $1 AND owner = $2`, table)
+
+	var expires time.Time
+	tryLock := func() (bool, error) {
+		start := time.Now()
+		result, err := db.ExecContext(ctx, acquireQuery, lockName, owner, ttl.Milliseconds())
+		if err != nil {
+			return false, fmt.Errorf("sessionlock(%s) failed to acquire lease: %w", lockName, err)
+		}
+		affected, err := result.RowsAffected()
+		expires = start.Add(ttl)
+		return affected == 1, err
+	}
+	holders := func() ([]Holder, error) {
+		return l.Holders(ctx, db, lockName)
+	}
+	if err := wait(ctx, lockName, opts, tryLock, holders); err != nil {
+		return err
+	}
+	defer func() {
+		// Release the lease even if ctx is done, so that the next caller does
+		// not have to wait for it to expire.
+		if _, err := db.ExecContext(context.WithoutCancel(ctx), releaseQuery, lockName, owner); err != nil {
+			final = multierr.Join(final, fmt.Errorf("sessionlock(%s) failed to release lease: %w", lockName, err))
+		}
+	}()
+
+	// Renew the lease in the background until cb returns. If it cannot be
+	// renewed before it expires, cancel cb's context, because another caller
+	// may acquire it from then on.
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			start := time.Now()
+			result, err := db.ExecContext(leaseCtx, renewQuery, lockName, owner, ttl.Milliseconds())
+			if err == nil {
+				var affected int64
+				affected, err = result.RowsAffected()
+				if err == nil && affected == 0 {
+					// Another caller took over the lease after it expired.
+					cancel(ErrLeaseLost)
+					return
+				}
+			}
+			if err == nil {
+				expires = start.Add(ttl)
+			} else if time.Now().After(expires) {
+				cancel(ErrLeaseLost)
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+		if errors.Is(context.Cause(leaseCtx), ErrLeaseLost) {
+			final = multierr.Join(final, fmt.Errorf("sessionlock(%s): %w", lockName, ErrLeaseLost))
+		}
+	}()
+
+	conn, err := db.Conn(leaseCtx)
+	if err != nil {
+		return fmt.Errorf("sessionlock(%s) failed to open conn: %w", lockName, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			final = multierr.Join(final, fmt.Errorf("sessionlock(%s) failed to close conn: %w", lockName, err))
+		}
+	}()
+	return cb(leaseCtx, conn)
+}
+
+// Holders returns the holder of the lease, if it is held and has not expired.
+func (l Lease) Holders(ctx context.Context, db Queryer, lockName string) ([]Holder, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, pgtools.Identifier(l.TableName)).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	query := fmt.Sprintf(`
+		SELECT owner, acquired_at, expires_at FROM %s
+		WHERE name = $1 AND expires_at >= now()
+	`, pgtools.Identifier(l.TableName))
+	rows, err := db.QueryContext(ctx, query, lockName)
+	if err != nil {
+		return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+	}
+	defer rows.Close()
+	var holders []Holder
+	for rows.Next() {
+		var holder Holder
+		if err := rows.Scan(&holder.Owner, &holder.AcquiredAt, &holder.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+		}
+		holders = append(holders, holder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sessionlock(%s) failed to find holders: %w", lockName, err)
+	}
+	return holders, nil
+}
+
+// ensureTable creates the lock table if it does not exist. The table is
+// created while holding a transaction-level advisory lock, which works through
+// any connection pooler, so that concurrent callers do not race to create it.
+func (l Lease) ensureTable(ctx context.Context, db *sql.DB) (final error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if final != nil {
+			final = multierr.Join(final, tx.Rollback())
+		} else {
+			final = tx.Commit()
+		}
+	}()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ID(l.TableName)); err != nil {
+		return err
+	}
+	schema, _ := pgtools.ParseTableName(l.TableName)
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, pgtools.Identifier(schema))); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name TEXT PRIMARY KEY,
+			owner TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL,
+			renewed_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)
+	`, pgtools.Identifier(l.TableName)))
+	return err
+}
+
+// defaultOwner identifies this process and this attempt to acquire a lease.
+func defaultOwner() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
@@ -0,0 +1,154 @@
+package sessionlock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestLeaseExcludesOtherHolders(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		var counter int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lease := Lease{TableName: "public.test_locks"}
+				err := lease.With(ctx, db, "test-lease", Options{SpinWait: 10 * time.Millisecond}, func(_ context.Context, _ *sql.Conn) error {
+					check.Equal(t, int32(1), atomic.AddInt32(&counter, 1))
+					time.Sleep(10 * time.Millisecond)
+					check.Equal(t, int32(0), atomic.AddInt32(&counter, -1))
+					return nil
+				})
+				check.Nil(t, err)
+			}()
+		}
+		wg.Wait()
+		// Released leases are deleted.
+		holders, err := Lease{TableName: "public.test_locks"}.Holders(ctx, db, "test-lease")
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(holders))
+		return nil
+	}))
+}
+
+func TestLeaseHoldersWithoutTable(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		holders, err := Lease{TableName: "public.missing_locks"}.Holders(ctx, db, "test-lease")
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(holders))
+		return nil
+	}))
+}
+
+func TestLeaseTakesOverExpiredLease(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lease := Lease{TableName: "test_locks", Owner: "new-owner"}
+		assert.Nil(t, lease.ensureTable(ctx, db))
+		// A migrator that crashed left its lease behind.
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO test_locks (name, owner, acquired_at, renewed_at, expires_at)
+			VALUES ('test-lease', 'crashed', now() - interval '1 hour', now() - interval '1 hour', now() - interval '1 minute')
+		`)
+		assert.Nil(t, err)
+		err = lease.With(ctx, db, "test-lease", Options{NoWait: true}, func(ctx context.Context, _ *sql.Conn) error {
+			holders, err := lease.Holders(ctx, db, "test-lease")
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(holders))
+			check.Equal(t, "new-owner", holders[0].Owner)
+			check.True(t, holders[0].ExpiresAt.After(time.Now()))
+			return nil
+		})
+		check.Nil(t, err)
+		return nil
+	}))
+}
+
+func TestLeaseGivesUpWhenHeld(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lease := Lease{TableName: "test_locks"}
+		return lease.With(ctx, db, "test-lease", Options{}, func(ctx context.Context, _ *sql.Conn) error {
+			var reported []Holder
+			err := lease.With(ctx, db, "test-lease", Options{
+				MaxWait:  100 * time.Millisecond,
+				SpinWait: 10 * time.Millisecond,
+				OnWait: func(_ context.Context, _ time.Duration, holders []Holder, err error) {
+					check.Nil(t, err)
+					reported = append(reported, holders...)
+				},
+			}, func(_ context.Context, _ *sql.Conn) error {
+				t.Error("acquired a lease that is already held")
+				return nil
+			})
+			check.True(t, errors.Is(err, ErrLockHeld))
+			assert.Equal(t, 1, len(reported))
+			check.NotEqual(t, "", reported[0].Owner)
+			return nil
+		})
+	}))
+}
+
+func TestLeaseIsRenewed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lease := Lease{TableName: "test_locks", TTL: 300 * time.Millisecond}
+		err := lease.With(ctx, db, "test-lease", Options{}, func(ctx context.Context, _ *sql.Conn) error {
+			// Hold the lease for several times its TTL.
+			time.Sleep(time.Second)
+			check.Nil(t, ctx.Err())
+			holders, err := lease.Holders(ctx, db, "test-lease")
+			assert.Nil(t, err)
+			check.Equal(t, 1, len(holders))
+			return nil
+		})
+		check.Nil(t, err)
+		return nil
+	}))
+}
+
+func TestLeaseLostCancelsCallback(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lease := Lease{TableName: "test_locks", TTL: 300 * time.Millisecond}
+		err := lease.With(ctx, db, "test-lease", Options{}, func(ctx context.Context, _ *sql.Conn) error {
+			// Simulate another migrator taking over the lease.
+			if _, err := db.ExecContext(ctx, `UPDATE test_locks SET owner = 'someone-else'`); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				t.Error("the callback's context was not canceled")
+				return nil
+			}
+		})
+		check.True(t, errors.Is(err, ErrLeaseLost))
+		// The other migrator's lease is left alone.
+		holders, err := lease.Holders(ctx, db, "test-lease")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(holders))
+		check.Equal(t, "someone-else", holders[0].Owner)
+		return nil
+	}))
+}
@@ -8,8 +8,9 @@ package sessionlock
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"hash/crc32"
+	"hash/fnv"
 	"time"
 
 	"github.com/peterldowns/pgmigrate/internal/multierr"
@@ -20,14 +21,74 @@ import (
 // own locks.
 const IDPrefix string = "sessionlock-"
 
-// SpinWait is the amount of time that sessionlock will sleep between attempts
-// to acquire an in-use session lock with `pg_try_advisory_lock`.
+// SpinWait is the default amount of time that sessionlock will sleep between
+// attempts to acquire an in-use session lock with `pg_try_advisory_lock`.
 const SpinWait time.Duration = 100 * time.Millisecond
 
-// ID consistently hashes a string to unique integer that can be used with
-// pg_advisory_lock() and pg_advisory_unlock().
-func ID(name string) uint32 {
-	return crc32.ChecksumIEEE([]byte(IDPrefix + name))
+// ErrLockHeld is returned by [WithOptions] when it gives up on acquiring a lock
+// because another session holds it.
+var ErrLockHeld = errors.New("lock is held by another session")
+
+// ID consistently hashes a string to a 64-bit integer that can be used with
+// pg_advisory_lock() and pg_advisory_unlock(). The hash is 64-bit FNV-1a, so
+// that collisions with the locks of other applications are practically
+// impossible.
+func ID(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(IDPrefix + name))
+	return int64(h.Sum64()) //nolint:gosec // the key is just the hash's bits
+}
+
+// DefaultReportInterval is how often [WithOptions] reports the holder of the
+// lock to [Options.OnWait] while it waits to acquire the lock.
+const DefaultReportInterval time.Duration = 10 * time.Second
+
+// Options configures how [WithOptions] acquires the lock.
+type Options struct {
+	// OnWait, if set, is called while waiting for the lock with the sessions
+	// that currently hold it, once as soon as the lock turns out to be held
+	// and then every ReportInterval. err is set if the holders could not be
+	// looked up.
+	OnWait func(ctx context.Context, waited time.Duration, holders []Holder, err error)
+	// ReportInterval is how often OnWait is called. If it is zero,
+	// [DefaultReportInterval] is used.
+	ReportInterval time.Duration
+	// MaxWait, if non-zero, is the longest to wait for the lock before giving
+	// up with [ErrLockHeld]. Otherwise, the wait only ends if the context
+	// does.
+	MaxWait time.Duration
+	// NoWait, if true, gives up with [ErrLockHeld] immediately if the lock is
+	// held, without waiting at all.
+	NoWait bool
+	// SpinWait is how long to sleep between attempts to acquire the lock. If
+	// it is zero, [SpinWait] is used.
+	SpinWait time.Duration
+}
+
+// Locker is a strategy for acquiring a named lock that makes sure that only
+// one caller at a time runs its callback. The callback is given a connection
+// to run its queries on, and a context that is canceled if the lock is lost
+// before the callback returns.
+type Locker interface {
+	With(ctx context.Context, db *sql.DB, lockName string, opts Options, cb func(context.Context, *sql.Conn) error) error
+	Holders(ctx context.Context, db Queryer, lockName string) ([]Holder, error)
+}
+
+// Session is a [Locker] that uses a session-level advisory lock, see
+// [WithOptions]. The lock is held by the connection given to the callback, so
+// it cannot be used through a connection pooler like PgBouncer in transaction
+// pooling mode, which may run each transaction on a different server
+// connection.
+type Session struct{}
+
+func (Session) With(ctx context.Context, db *sql.DB, lockName string, opts Options, cb func(context.Context, *sql.Conn) error) error {
+	return WithOptions(ctx, db, lockName, opts, func(conn *sql.Conn) error {
+		return cb(ctx, conn)
+	})
+}
+
+func (Session) Holders(ctx context.Context, db Queryer, lockName string) ([]Holder, error) {
+	return Holders(ctx, db, lockName)
 }
 
 // With will open a connection to the `db`, acquire an advisory lock, use that
@@ -36,7 +97,12 @@ func ID(name string) uint32 {
 //
 // With will spin indefinitely using `pg_try_advisory_lock` to acquire the lock,
 // giving up only if the lock is acquired or if the provided `ctx` expires.
-func With(ctx context.Context, db *sql.DB, lockName string, cb func(*sql.Conn) error) (final error) {
+func With(ctx context.Context, db *sql.DB, lockName string, cb func(*sql.Conn) error) error {
+	return WithOptions(ctx, db, lockName, Options{}, cb)
+}
+
+// WithOptions is like [With], but acquires the lock as configured by opts.
+func WithOptions(ctx context.Context, db *sql.DB, lockName string, opts Options, cb func(*sql.Conn) error) (final error) {
 	// Uses a *sql.Conn here to guarantee that lock() and unlock() happen in the
 	// same session.
 	conn, err := db.Conn(ctx)
@@ -56,25 +122,87 @@ func With(ctx context.Context, db *sql.DB, lockName string, cb func(*sql.Conn) e
 	id := ID(lockName)
 	tryLockQuery := fmt.Sprintf("SELECT pg_try_advisory_lock(%d)", id)
 	unlockQuery := fmt.Sprintf("SELECT pg_advisory_unlock(%d)", id)
-	for {
+	tryLock := func() (bool, error) {
+		var locked bool
+		err := conn.QueryRowContext(ctx, tryLockQuery).Scan(&locked)
+		return locked, err
+	}
+	holders := func() ([]Holder, error) {
+		return Holders(ctx, conn, lockName)
+	}
+	if err := wait(ctx, lockName, opts, tryLock, holders); err != nil {
+		return err
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(ctx, unlockQuery); err != nil {
+			final = multierr.Join(final, fmt.Errorf("sessionlock(%s) failed to unlock: %w", lockName, err))
+		}
+	}()
+	return cb(conn)
+}
+
+// LockTx acquires a transaction-level advisory lock with the same ID as the
+// session-level lock taken by [WithOptions], waiting for it as configured by
+// opts. The lock is released when tx commits or rolls back. Like
+// [WithOptions], it spins with `pg_try_advisory_xact_lock` so that waiting is
+// not cut short by the `lock_timeout` or `statement_timeout` settings.
+func LockTx(ctx context.Context, tx *sql.Tx, lockName string, opts Options) error {
+	tryLockQuery := fmt.Sprintf("SELECT pg_try_advisory_xact_lock(%d)", ID(lockName))
+	tryLock := func() (bool, error) {
 		var locked bool
-		if err := conn.QueryRowContext(ctx, tryLockQuery).Scan(&locked); err != nil {
+		err := tx.QueryRowContext(ctx, tryLockQuery).Scan(&locked)
+		return locked, err
+	}
+	holders := func() ([]Holder, error) {
+		return Holders(ctx, tx, lockName)
+	}
+	return wait(ctx, lockName, opts, tryLock, holders)
+}
+
+// wait calls tryLock until it acquires the lock, following opts, and reports
+// the lock's holders while it waits.
+func wait(
+	ctx context.Context,
+	lockName string,
+	opts Options,
+	tryLock func() (bool, error),
+	holders func() ([]Holder, error),
+) error {
+	reportInterval := opts.ReportInterval
+	if reportInterval <= 0 {
+		reportInterval = DefaultReportInterval
+	}
+	spinWait := opts.SpinWait
+	if spinWait <= 0 {
+		spinWait = SpinWait
+	}
+	start := time.Now()
+	var lastReport time.Time
+	for {
+		locked, err := tryLock()
+		if err != nil {
 			return err
 		}
 		if locked {
-			break
+			return nil
+		}
+		if opts.NoWait {
+			return fmt.Errorf("sessionlock(%s): %w", lockName, ErrLockHeld)
+		}
+		waited := time.Since(start)
+		if opts.MaxWait > 0 && waited >= opts.MaxWait {
+			return fmt.Errorf("sessionlock(%s) gave up after %s: %w", lockName, opts.MaxWait, ErrLockHeld)
+		}
+		if opts.OnWait != nil && (lastReport.IsZero() || time.Since(lastReport) >= reportInterval) {
+			lastReport = time.Now()
+			current, err := holders()
+			opts.OnWait(ctx, waited, current, err)
 		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(SpinWait):
+		case <-time.After(spinWait):
 		}
 	}
-
-	defer func() {
-		if _, err := conn.ExecContext(ctx, unlockQuery); err != nil {
-			final = multierr.Join(final, fmt.Errorf("sessionlock(%s) failed to unlock: %w", lockName, err))
-		}
-	}()
-	return cb(conn)
 }
@@ -3,6 +3,7 @@ package sessionlock
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -174,3 +175,99 @@ func waitForAcquired(errch chan error, lockch chan struct{}, timeout time.Durati
 		return fmt.Errorf("timed out waiting for lock acquisition")
 	}
 }
+
+func TestWithOptionsReportsHolderWhileWaiting(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lockName := "test-with-options-reports-holder"
+		return With(ctx, db, lockName, func(conn *sql.Conn) error {
+			var pid int
+			if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+				return err
+			}
+			holders, err := Holders(ctx, db, lockName)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(holders))
+			check.Equal(t, pid, holders[0].PID)
+
+			// A second attempt to acquire the lock waits, and reports the
+			// session that holds it, until the context expires.
+			waitCtx, cancel := context.WithTimeout(ctx, 250*time.Millisecond)
+			defer cancel()
+			var reported []Holder
+			err = WithOptions(waitCtx, db, lockName, Options{
+				ReportInterval: time.Hour,
+				OnWait: func(_ context.Context, _ time.Duration, holders []Holder, err error) {
+					check.Nil(t, err)
+					reported = append(reported, holders...)
+				},
+			}, func(_ *sql.Conn) error {
+				t.Error("acquired a lock that is already held")
+				return nil
+			})
+			check.Error(t, err)
+			assert.Equal(t, 1, len(reported))
+			check.Equal(t, pid, reported[0].PID)
+			return nil
+		})
+	}))
+}
+
+func TestWithOptionsGivesUpWhenLockIsHeld(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lockName := "test-with-options-gives-up"
+		return With(ctx, db, lockName, func(_ *sql.Conn) error {
+			for _, opts := range []Options{
+				{NoWait: true},
+				{MaxWait: 100 * time.Millisecond, SpinWait: 10 * time.Millisecond},
+			} {
+				err := WithOptions(ctx, db, lockName, opts, func(_ *sql.Conn) error {
+					t.Error("acquired a lock that is already held")
+					return nil
+				})
+				check.True(t, errors.Is(err, ErrLockHeld))
+			}
+			return nil
+		})
+	}))
+}
+
+func TestLockTxConflictsWithSessionLock(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	check.Nil(t, withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		lockName := "test-lock-tx"
+		opts := Options{NoWait: true}
+		err := With(ctx, db, lockName, func(_ *sql.Conn) error {
+			tx, err := db.BeginTx(ctx, nil)
+			assert.Nil(t, err)
+			defer func() { check.Nil(t, tx.Rollback()) }()
+			check.True(t, errors.Is(LockTx(ctx, tx, lockName, opts), ErrLockHeld))
+			return nil
+		})
+		assert.Nil(t, err)
+
+		// The transaction-level lock is held until the transaction ends.
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, LockTx(ctx, tx, lockName, opts))
+		err = WithOptions(ctx, db, lockName, opts, func(_ *sql.Conn) error {
+			t.Error("acquired a lock that is already held")
+			return nil
+		})
+		check.True(t, errors.Is(err, ErrLockHeld))
+		assert.Nil(t, tx.Commit())
+		return WithOptions(ctx, db, lockName, opts, func(_ *sql.Conn) error { return nil })
+	}))
+}
+
+func TestIDIsStable(t *testing.T) {
+	t.Parallel()
+	// Every version of sessionlock must compute the same ID for a name, or
+	// two versions could hold the "same" lock at the same time.
+	check.Equal(t, int64(4465898764119594111), ID("example"))
+	check.NotEqual(t, ID("example"), ID("example2"))
+}
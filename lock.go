@@ -0,0 +1,318 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/peterldowns/pgmigrate/internal/sessionlock"
+)
+
+// ErrLockHeld is returned by [Migrator.Migrate] when it gives up on acquiring
+// the migrations lock because another migrator holds it, either immediately
+// because of [Migrator.LockNoWait] or after [Migrator.LockMaxWait].
+var ErrLockHeld = sessionlock.ErrLockHeld
+
+// ErrLeaseLost is the cause of the cancellation of the context given to the
+// callback of [LeaseLocker.WithLock] if the lease expired before it could be
+// renewed, and is returned by [Migrator.Migrate] in that case.
+var ErrLeaseLost = sessionlock.ErrLeaseLost
+
+// Locker is how [Migrator.Migrate] makes sure that only one migrator applies
+// migrations at a time. pgmigrate includes two implementations:
+//
+//   - [SessionLocker], the default, which uses a Postgres session-level
+//     advisory lock.
+//   - [LeaseLocker], which uses a row in a lock table, and works through
+//     connection poolers like PgBouncer in transaction pooling mode.
+type Locker interface {
+	// WithLock acquires the lock with the given name, waiting for it as
+	// configured by opts, then calls cb and releases the lock. cb is given a
+	// connection to apply the migrations on, and a context that is canceled
+	// if the lock is lost before cb returns.
+	WithLock(ctx context.Context, db *sql.DB, name string, opts LockOptions, cb func(context.Context, *sql.Conn) error) error
+	// LockHolders returns the current holders of the lock with the given
+	// name.
+	LockHolders(ctx context.Context, db Executor, name string) ([]LockHolder, error)
+}
+
+// LockOptions configures how a [Locker] waits for a lock that is held by
+// someone else. [Migrator.Migrate] sets them from the Migrator's Lock* fields.
+type LockOptions struct {
+	// MaxWait, if non-zero, is the longest to wait before giving up with
+	// [ErrLockHeld].
+	MaxWait time.Duration
+	// NoWait, if true, gives up with [ErrLockHeld] without waiting.
+	NoWait bool
+	// SpinInterval is how long to wait between attempts to acquire the lock.
+	SpinInterval time.Duration
+	// OnWait, if set, is called with the holders of the lock while waiting
+	// for it, once right away and then every ReportInterval.
+	OnWait         func(ctx context.Context, waited time.Duration, holders []LockHolder, err error)
+	ReportInterval time.Duration
+}
+
+// LockHolder describes a holder of the lock that [Migrator.Migrate] uses to
+// make sure that only one migrator applies migrations at a time.
+//
+// With a [SessionLocker], this is the session that holds the advisory lock,
+// and the details come from `pg_stat_activity`; Postgres hides some of them
+// from users that are not superusers or members of `pg_read_all_stats`, and
+// those are left empty. With a [LeaseLocker], only Owner, AcquiredAt, and
+// ExpiresAt are set.
+type LockHolder struct {
+	// PID is the process ID of the backend serving the session, which can be
+	// passed to [Migrator.TerminateLockHolder].
+	PID int
+	// Username is the Postgres user of the session.
+	Username string
+	// ApplicationName is the `application_name` of the session.
+	ApplicationName string
+	// ClientAddr is the address of the client, or empty if the client is
+	// connected through a Unix socket.
+	ClientAddr string
+	// QueryStart is when the session's current or most recent query started.
+	QueryStart time.Time
+	// State is the state of the session, like "active" or "idle in
+	// transaction".
+	State string
+	// Owner identifies the migrator that holds a lease.
+	Owner string
+	// AcquiredAt is when the lease was acquired.
+	AcquiredAt time.Time
+	// ExpiresAt is when the lease expires unless its holder renews it.
+	ExpiresAt time.Time
+}
+
+// SessionLocker is a [Locker] that uses a Postgres session-level advisory
+// lock, which is held by the connection that the migrations are applied on.
+// If the migrator crashes, Postgres releases the lock as soon as the
+// connection is closed.
+//
+// Because the lock belongs to a session, it does not work through a connection
+// pooler that may run each transaction on a different server connection, like
+// PgBouncer in transaction pooling mode. Use a [LeaseLocker] instead.
+type SessionLocker struct{}
+
+func (SessionLocker) WithLock(ctx context.Context, db *sql.DB, name string, opts LockOptions, cb func(context.Context, *sql.Conn) error) error {
+	return sessionlock.Session{}.With(ctx, db, name, sessionlockOptions(opts), cb)
+}
+
+func (SessionLocker) LockHolders(ctx context.Context, db Executor, name string) ([]LockHolder, error) {
+	holders, err := sessionlock.Session{}.Holders(ctx, db, name)
+	return lockHolders(holders), err
+}
+
+// DefaultLeaseTableName is the default name of the table (with schema) that a
+// [LeaseLocker] keeps its leases in.
+const DefaultLeaseTableName string = "public.pgmigrate_locks"
+
+// DefaultLeaseTTL is the default amount of time that a [LeaseLocker]'s lease
+// lasts without being renewed.
+const DefaultLeaseTTL time.Duration = sessionlock.DefaultLeaseTTL
+
+// LeaseLocker is a [Locker] that holds a lease: a row in a lock table, with
+// the lease's owner and its expiry time. While the migrations are applied,
+// the lease is renewed every third of its TTL. If the migrator crashes, the
+// lease expires after its TTL and the next migrator takes it over.
+//
+// Every query that a LeaseLocker runs is its own transaction, so it works
+// through any connection pooler, including PgBouncer in transaction pooling
+// mode. If the lease cannot be renewed before it expires, the migrations are
+// stopped, and [Migrator.Migrate] returns [ErrLeaseLost].
+//
+// A [Migration.NoTransaction] migration's lock_timeout and statement_timeout
+// are session settings, which a pooler in transaction pooling mode does not
+// keep on the same server connection as the migration's statements. With a
+// LeaseLocker, they are reset with `RESET` after the migration so that they
+// do not outlive it, but they may not apply to the migration's statements
+// either, so use a session pooling connection to apply such migrations if
+// their timeouts matter.
+//
+// LeaseLocker should be instantiated with [NewLeaseLocker].
+type LeaseLocker struct {
+	// TableName is the table that the leases are kept in, which is created
+	// if it does not exist.
+	//
+	// [NewLeaseLocker] defaults it to [DefaultLeaseTableName].
+	TableName string
+	// TTL is how long a lease lasts without being renewed. It should be
+	// much longer than a slow query to renew it might take.
+	//
+	// [NewLeaseLocker] defaults it to [DefaultLeaseTTL].
+	TTL time.Duration
+	// Owner identifies the migrator in the lock table, and is shown by
+	// [Migrator.LockHolders]. It must be unique to each migrator.
+	//
+	// [NewLeaseLocker] defaults it to empty, and each lease gets a unique
+	// owner made of the hostname, the process ID, and a random suffix.
+	Owner string
+}
+
+// NewLeaseLocker creates a [LeaseLocker] and sets appropriate default values
+// for all configurable fields:
+//
+//   - TableName: [DefaultLeaseTableName]
+//   - TTL: [DefaultLeaseTTL]
+//   - Owner: empty, each lease gets a unique owner
+//
+// To configure these fields, just set the values on the struct.
+func NewLeaseLocker() *LeaseLocker {
+	return &LeaseLocker{
+		TableName: DefaultLeaseTableName,
+		TTL:       DefaultLeaseTTL,
+		Owner:     "",
+	}
+}
+
+func (l *LeaseLocker) WithLock(ctx context.Context, db *sql.DB, name string, opts LockOptions, cb func(context.Context, *sql.Conn) error) error {
+	return l.lease().With(ctx, db, name, sessionlockOptions(opts), cb)
+}
+
+func (l *LeaseLocker) LockHolders(ctx context.Context, db Executor, name string) ([]LockHolder, error) {
+	holders, err := l.lease().Holders(ctx, db, name)
+	return lockHolders(holders), err
+}
+
+func (l *LeaseLocker) lease() sessionlock.Lease {
+	return sessionlock.Lease{TableName: l.TableName, TTL: l.TTL, Owner: l.Owner}
+}
+
+// LockHolders returns the current holders of the migrations lock. There is at
+// most one, unless a [SessionLocker]'s advisory lock is also held by a
+// session that is not a pgmigrate migrator.
+func (m *Migrator) LockHolders(ctx context.Context, db Executor) ([]LockHolder, error) {
+	return m.locker().LockHolders(ctx, db, m.lockName())
+}
+
+// TerminateLockHolder ends the session with the given PID, which releases the
+// migrations lock that it holds. To avoid ending an unrelated session that has
+// reused the PID, the session is only terminated if it still holds the lock.
+// It returns false if the session no longer holds the lock.
+//
+// Terminating a migrator while it applies a migration rolls back that
+// migration, unless it is a [Migration.NoTransaction] migration, which may be
+// left partially applied.
+//
+// This only works with a [SessionLocker]. The lease of a [LeaseLocker] whose
+// migrator crashed expires on its own.
+func (m *Migrator) TerminateLockHolder(ctx context.Context, db Executor, pid int) (bool, error) {
+	switch m.locker().(type) {
+	case SessionLocker, *SessionLocker:
+	default:
+		return false, fmt.Errorf("only the holders of session locks can be terminated")
+	}
+	m.warn(ctx, "terminating migrations lock holder", LogField{Key: "pid", Value: pid})
+	return sessionlock.Terminate(ctx, db, m.lockName(), pid)
+}
+
+// locker returns [Migrator.Locker], or a [SessionLocker] if it is not set.
+func (m *Migrator) locker() Locker {
+	if m.Locker == nil {
+		return SessionLocker{}
+	}
+	return m.Locker
+}
+
+// lockOptions configures how [Migrator.Migrate] waits for the migrations lock.
+func (m *Migrator) lockOptions() LockOptions {
+	opts := LockOptions{
+		MaxWait:      m.LockMaxWait,
+		NoWait:       m.LockNoWait,
+		SpinInterval: m.LockSpinInterval,
+	}
+	if m.LockWaitLogInterval <= 0 {
+		return opts
+	}
+	opts.ReportInterval = m.LockWaitLogInterval
+	opts.OnWait = func(ctx context.Context, waited time.Duration, holders []LockHolder, err error) {
+		m.emit(ctx, LockWaiting{Waited: waited, Holders: holders})
+		waitedField := LogField{Key: "waited", Value: waited.Round(time.Second).String()}
+		if err != nil {
+			m.warn(ctx, "waiting for migrations lock", waitedField, LogField{Key: "error", Value: err})
+			return
+		}
+		if len(holders) == 0 {
+			m.info(ctx, "waiting for migrations lock", waitedField)
+			return
+		}
+		for _, holder := range holders {
+			m.info(ctx, "waiting for migrations lock", append([]LogField{waitedField}, holder.logFields()...)...)
+		}
+	}
+	return opts
+}
+
+// logLockHolders logs the holders of the migrations lock after
+// [Migrator.Migrate] gave up on acquiring it.
+func (m *Migrator) logLockHolders(ctx context.Context, db Executor) {
+	holders, err := m.LockHolders(ctx, db)
+	if err != nil {
+		m.warn(ctx, "failed to find migrations lock holders", LogField{Key: "error", Value: err})
+		return
+	}
+	for _, holder := range holders {
+		m.warn(ctx, "migrations lock is held", holder.logFields()...)
+	}
+}
+
+func (h LockHolder) logFields() []LogField {
+	var fields []LogField
+	if h.PID != 0 {
+		fields = append(fields, LogField{Key: "holder_pid", Value: h.PID})
+	}
+	for _, field := range []struct{ key, value string }{
+		{"holder_username", h.Username},
+		{"holder_application_name", h.ApplicationName},
+		{"holder_client_addr", h.ClientAddr},
+		{"holder_state", h.State},
+		{"holder_owner", h.Owner},
+	} {
+		if field.value != "" {
+			fields = append(fields, LogField{Key: field.key, Value: field.value})
+		}
+	}
+	for _, field := range []struct {
+		key   string
+		value time.Time
+	}{
+		{"holder_query_start", h.QueryStart},
+		{"holder_acquired_at", h.AcquiredAt},
+		{"holder_expires_at", h.ExpiresAt},
+	} {
+		if !field.value.IsZero() {
+			fields = append(fields, LogField{Key: field.key, Value: field.value.Format(time.RFC3339)})
+		}
+	}
+	return fields
+}
+
+func sessionlockOptions(opts LockOptions) sessionlock.Options {
+	result := sessionlock.Options{
+		MaxWait:        opts.MaxWait,
+		NoWait:         opts.NoWait,
+		SpinWait:       opts.SpinInterval,
+		ReportInterval: opts.ReportInterval,
+	}
+	if opts.OnWait != nil {
+		result.OnWait = func(ctx context.Context, waited time.Duration, holders []sessionlock.Holder, err error) {
+			opts.OnWait(ctx, waited, lockHolders(holders), err)
+		}
+	}
+	return result
+}
+
+func lockHolders(holders []sessionlock.Holder) []LockHolder {
+	var result []LockHolder
+	for _, holder := range holders {
+		result = append(result, LockHolder(holder))
+	}
+	return result
+}
+
+// lockName is the name of the lock that [Migrator.Migrate] holds while it
+// applies migrations.
+func (m *Migrator) lockName() string {
+	return fmt.Sprintf("%s-%s", m.LockNamespace, m.TableName)
+}
@@ -0,0 +1,230 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestLockHoldersWhileMigrating(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = logger
+		holders, err := migrator.LockHolders(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(holders))
+
+		migrator.Hooks.BeforeMigrate = func(ctx context.Context, _ []pgmigrate.Migration) error {
+			holders, err := migrator.LockHolders(ctx, db)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(holders))
+			check.NotEqual(t, 0, holders[0].PID)
+			check.False(t, holders[0].QueryStart.IsZero())
+			return nil
+		}
+		verrs, err := migrator.Migrate(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		holders, err = migrator.LockHolders(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(holders))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTerminateLockHolderReleasesLock(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = logger
+		// Unknown PIDs are never terminated.
+		terminated, err := migrator.TerminateLockHolder(ctx, db, 1)
+		assert.Nil(t, err)
+		check.False(t, terminated)
+
+		migrator.Hooks.BeforeMigrate = func(ctx context.Context, _ []pgmigrate.Migration) error {
+			holders, err := migrator.LockHolders(ctx, db)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(holders))
+			terminated, err := migrator.TerminateLockHolder(ctx, db, holders[0].PID)
+			assert.Nil(t, err)
+			check.True(t, terminated)
+			return nil
+		}
+		_, err = migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		holders, err := migrator.LockHolders(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(holders))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateGivesUpWhenLockIsHeld(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.Hooks.BeforeMigrate = func(ctx context.Context, _ []pgmigrate.Migration) error {
+			noWait := pgmigrate.NewMigrator(migrations)
+			noWait.Logger = logger
+			noWait.LockNoWait = true
+			_, err := noWait.Migrate(ctx, db)
+			check.True(t, errors.Is(err, pgmigrate.ErrLockHeld))
+
+			maxWait := pgmigrate.NewMigrator(migrations)
+			maxWait.Logger = logger
+			maxWait.LockMaxWait = 200 * time.Millisecond
+			maxWait.LockSpinInterval = 10 * time.Millisecond
+			start := time.Now()
+			_, err = maxWait.Migrate(ctx, db)
+			check.True(t, errors.Is(err, pgmigrate.ErrLockHeld))
+			check.True(t, time.Since(start) >= 200*time.Millisecond)
+			return nil
+		}
+		_, err := migrator.Migrate(ctx, db)
+		check.Nil(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestLockNamespaceSeparatesLocks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator(nil)
+		migrator.Logger = logger
+		migrator.LockNamespace = "app-one"
+		migrator.Hooks.BeforeMigrate = func(ctx context.Context, _ []pgmigrate.Migration) error {
+			other := pgmigrate.NewMigrator(nil)
+			other.Logger = logger
+			other.LockNamespace = "app-two"
+			holders, err := other.LockHolders(ctx, db)
+			assert.Nil(t, err)
+			check.Equal(t, 0, len(holders))
+
+			same := pgmigrate.NewMigrator(nil)
+			same.Logger = logger
+			same.LockNamespace = "app-one"
+			holders, err = same.LockHolders(ctx, db)
+			assert.Nil(t, err)
+			check.Equal(t, 1, len(holders))
+			return nil
+		}
+		_, err := migrator.Migrate(ctx, db)
+		check.Nil(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestLeaseLockerMigrates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = logger
+		locker := pgmigrate.NewLeaseLocker()
+		locker.Owner = "test-migrator"
+		migrator.Locker = locker
+		migrator.Hooks.BeforeMigrate = func(ctx context.Context, _ []pgmigrate.Migration) error {
+			holders, err := migrator.LockHolders(ctx, db)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(holders))
+			check.Equal(t, "test-migrator", holders[0].Owner)
+			check.Equal(t, 0, holders[0].PID)
+
+			// Another migrator with a lease cannot acquire it.
+			other := pgmigrate.NewMigrator(nil)
+			other.Logger = logger
+			other.Locker = pgmigrate.NewLeaseLocker()
+			other.LockNoWait = true
+			_, err = other.Migrate(ctx, db)
+			check.True(t, errors.Is(err, pgmigrate.ErrLockHeld))
+
+			_, err = migrator.TerminateLockHolder(ctx, db, 1)
+			check.Error(t, err)
+			return nil
+		}
+		verrs, err := migrator.Migrate(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		holders, err := migrator.LockHolders(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(holders))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestLeaseLockerResetsNoTransactionTimeouts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{
+				ID:            "0001_timeout",
+				SQL:           "CREATE TABLE settings AS SELECT current_setting('lock_timeout') AS lock_timeout;",
+				NoTransaction: true,
+				LockTimeout:   3 * time.Second,
+			},
+			{
+				ID:            "0002_default",
+				SQL:           "INSERT INTO settings SELECT current_setting('lock_timeout');",
+				NoTransaction: true,
+			},
+		})
+		migrator.Logger = logger
+		migrator.Locker = pgmigrate.NewLeaseLocker()
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		rows, err := db.QueryContext(ctx, "SELECT lock_timeout FROM settings")
+		assert.Nil(t, err)
+		defer rows.Close()
+		var settings []string
+		for rows.Next() {
+			var lockTimeout string
+			assert.Nil(t, rows.Scan(&lockTimeout))
+			settings = append(settings, lockTimeout)
+		}
+		assert.Nil(t, rows.Err())
+		check.Equal(t, []string{"3s", "0"}, settings)
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -3,6 +3,7 @@ package pgmigrate
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"testing"
 )
 
@@ -70,3 +71,45 @@ func (t TestLogger) Log(_ context.Context, level LogLevel, msg string, fields ..
 	}
 	t.TB.Log(line)
 }
+
+// NewSlogLogger returns a [SlogLogger], which is a [Logger] that writes to the
+// given [slog.Logger]. If logger is nil, it writes to [slog.Default].
+func NewSlogLogger(logger *slog.Logger) SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return SlogLogger{logger}
+}
+
+// SlogLogger implements the [Logger] interface and writes all logs to a
+// [slog.Logger], with each [LogField] as an attribute. The [LogLevel] of each
+// message is mapped to the slog level of the same name, so debug messages,
+// like the queries that pgmigrate runs, are only written if the logger's
+// handler is enabled for [slog.LevelDebug].
+type SlogLogger struct {
+	*slog.Logger
+}
+
+// Log writes a message to the slog logger.
+func (l SlogLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	l.Logger.LogAttrs(ctx, SlogLevel(level), msg, attrs...)
+}
+
+// SlogLevel returns the slog level that corresponds to a [LogLevel]. Unknown
+// levels are treated as [slog.LevelInfo].
+func SlogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelWarning:
+		return slog.LevelWarn
+	case LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,81 @@
+package pgmigrate_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestSlogLogger(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	logger := pgmigrate.NewSlogLogger(slog.New(handler))
+
+	logger.Log(ctx, pgmigrate.LogLevelDebug, "hidden")
+	logger.Log(ctx, pgmigrate.LogLevelInfo, "applied", pgmigrate.LogField{Key: "migration_id", Value: "0001_initial"})
+	logger.Log(ctx, pgmigrate.LogLevelWarning, "skipped")
+	logger.Log(ctx, pgmigrate.LogLevelError, "failed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	var records []map[string]any
+	for _, line := range lines {
+		var record map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	check.Equal(t, "applied", records[0]["msg"])
+	check.Equal(t, "INFO", records[0]["level"])
+	check.Equal(t, "0001_initial", records[0]["migration_id"])
+	check.Equal(t, "WARN", records[1]["level"])
+	check.Equal(t, "ERROR", records[2]["level"])
+}
+
+func TestSlogLevel(t *testing.T) {
+	t.Parallel()
+	check.Equal(t, slog.LevelDebug, pgmigrate.SlogLevel(pgmigrate.LogLevelDebug))
+	check.Equal(t, slog.LevelInfo, pgmigrate.SlogLevel(pgmigrate.LogLevelInfo))
+	check.Equal(t, slog.LevelWarn, pgmigrate.SlogLevel(pgmigrate.LogLevelWarning))
+	check.Equal(t, slog.LevelError, pgmigrate.SlogLevel(pgmigrate.LogLevelError))
+	check.Equal(t, slog.LevelInfo, pgmigrate.SlogLevel("unknown"))
+}
+
+func TestDebugQueryLogsIncludeDuration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		var buf bytes.Buffer
+		handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = pgmigrate.NewSlogLogger(slog.New(handler))
+		_, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+
+		queries := 0
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			assert.Nil(t, json.Unmarshal([]byte(line), &record))
+			if _, ok := record["duration"]; ok {
+				check.Equal(t, "DEBUG", record["level"])
+				queries++
+			}
+		}
+		check.True(t, queries > 0)
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,91 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/peterldowns/pgmigrate/internal/multierr"
+	"github.com/peterldowns/pgmigrate/internal/sessionlock"
+)
+
+// savepointName is the name of the savepoints that pgmigrate uses to make
+// changes inside of a transaction that it was given by the caller.
+const savepointName = "pgmigrate"
+
+// MigrateTx is like [Migrator.Migrate], but applies the migrations inside of
+// tx, a transaction that you control, and leaves it up to you to commit or
+// roll it back. This is useful for tests that roll back their migrations when
+// they are done, and for setting up a database (or a tenant's schema) as part
+// of a larger transaction.
+//
+// Instead of using [Migrator.Locker], MigrateTx holds a transaction-level
+// advisory lock (`pg_advisory_xact_lock`) until tx ends, waiting for it as
+// configured by the Migrator's Lock* fields. The lock has the same ID as the
+// lock held by a [SessionLocker], so it keeps other migrators that use a
+// SessionLocker from running at the same time, but not ones that use a
+// [LeaseLocker].
+//
+// Each migration is applied inside of a savepoint. If a migration fails, its
+// savepoint is rolled back and MigrateTx returns the error without attempting
+// any further migrations, so tx can still be used, and committed with the
+// migrations that were applied before the failure. If
+// [Migrator.SingleTransaction] is set, the whole plan is applied inside of one
+// savepoint instead, so a failed migration also rolls back every migration
+// before it. MigrateTx refuses to start if the plan contains a
+// [Migration.NoTransaction] migration.
+//
+// Errors that do not come from a migration, such as failing to create the
+// migrations table, may leave tx aborted, in which case it can only be rolled
+// back. Any history recorded in [Migrator.HistoryTableName] is part of tx, and
+// is rolled back along with it.
+func (m *Migrator) MigrateTx(ctx context.Context, tx *sql.Tx) ([]VerificationError, error) {
+	ctx, span := m.startSpan(ctx, SpanMigrate,
+		LogField{Key: "table_name", Value: m.TableName},
+		LogField{Key: "caller_transaction", Value: true},
+	)
+	applied, verrs, err := m.migrateTx(ctx, tx)
+	span.SetAttributes(LogField{Key: "applied", Value: len(applied)})
+	endSpan(span, err)
+	return verrs, err
+}
+
+// migrateTx acquires the transaction-level advisory lock and applies the plan
+// inside of tx. It returns the migrations that were applied.
+func (m *Migrator) migrateTx(ctx context.Context, tx *sql.Tx) ([]AppliedMigration, []VerificationError, error) {
+	lockStart := time.Now()
+	_, lockSpan := m.startSpan(ctx, SpanAcquireLock, LogField{Key: "lock_name", Value: m.lockName()})
+	err := sessionlock.LockTx(ctx, tx, m.lockName(), sessionlockOptions(m.lockOptions()))
+	endSpan(lockSpan, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.emit(ctx, LockAcquired{Waited: time.Since(lockStart)})
+	return m.migrateLocked(ctx, tx, tx, "")
+}
+
+// inSavepoint calls cb inside of a savepoint in tx. The savepoint is released
+// if cb succeeds, and rolled back to otherwise, which undoes cb's changes
+// without aborting the rest of tx.
+func (m *Migrator) inSavepoint(ctx context.Context, tx *sql.Tx, cb func(tx *sql.Tx) error) (final error) {
+	if _, err := m.execContext(ctx, tx, "SAVEPOINT "+savepointName); err != nil {
+		msg := "savepoint"
+		m.error(ctx, err, msg)
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	// Clean up the savepoint even if cb failed because ctx was canceled.
+	cleanupCtx := context.WithoutCancel(ctx)
+	defer func() {
+		if final != nil {
+			if _, err := m.execContext(cleanupCtx, tx, "ROLLBACK TO SAVEPOINT "+savepointName); err != nil {
+				final = multierr.Join(final, fmt.Errorf("savepoint rollback: %w", err))
+				return
+			}
+		}
+		if _, err := m.execContext(cleanupCtx, tx, "RELEASE SAVEPOINT "+savepointName); err != nil {
+			final = multierr.Join(final, fmt.Errorf("savepoint release: %w", err))
+		}
+	}()
+	return cb(tx)
+}
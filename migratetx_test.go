@@ -0,0 +1,195 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestMigrateTxLeavesCommitToCaller(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_posts", SQL: "CREATE TABLE posts (title text);"},
+		})
+		migrator.Logger = logger
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		verrs, err := migrator.MigrateTx(ctx, tx)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(verrs))
+		applied, err := migrator.Applied(ctx, tx)
+		assert.Nil(t, err)
+		check.Equal(t, 2, len(applied))
+		assert.Nil(t, tx.Rollback())
+
+		// Nothing was applied, not even the migrations table.
+		applied, err = migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+
+		tx, err = db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = migrator.MigrateTx(ctx, tx)
+		assert.Nil(t, err)
+		assert.Nil(t, tx.Commit())
+		applied, err = migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 2, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateTxRollsBackFailedMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_broken", SQL: "CREATE TABLE posts (title text); SELECT * FROM does_not_exist;"},
+			{ID: "0003_comments", SQL: "CREATE TABLE comments (body text);"},
+		})
+		migrator.Logger = logger
+		migrator.LockTimeout = 5 * time.Second
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = migrator.MigrateTx(ctx, tx)
+		check.Error(t, err)
+
+		// The transaction is still usable, the failed migration was rolled
+		// back, and the migration's lock_timeout did not leak into it.
+		var lockTimeout string
+		assert.Nil(t, tx.QueryRowContext(ctx, "SHOW lock_timeout").Scan(&lockTimeout))
+		check.Equal(t, "0", lockTimeout)
+		var posts bool
+		assert.Nil(t, tx.QueryRowContext(ctx, "SELECT to_regclass('posts') IS NOT NULL").Scan(&posts))
+		check.False(t, posts)
+		assert.Nil(t, tx.Commit())
+
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(applied))
+		check.Equal(t, "0001_users", applied[0].ID)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateTxSingleTransaction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_broken", SQL: "SELECT * FROM does_not_exist;"},
+		})
+		migrator.Logger = logger
+		migrator.SingleTransaction = true
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = migrator.MigrateTx(ctx, tx)
+		check.Error(t, err)
+		applied, err := migrator.Applied(ctx, tx)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return tx.Rollback()
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateTxRefusesNoTransactionMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_index", SQL: "CREATE INDEX CONCURRENTLY users_name ON users (name);", NoTransaction: true},
+		})
+		migrator.Logger = logger
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = migrator.MigrateTx(ctx, tx)
+		assert.Error(t, err)
+		check.True(t, strings.Contains(err.Error(), "0002_index"))
+		applied, err := migrator.Applied(ctx, tx)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return tx.Rollback()
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateTxSharesLockWithMigrate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		}
+		inTx := pgmigrate.NewMigrator(migrations)
+		inTx.Logger = logger
+		inTx.LockNoWait = true
+
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.Hooks.BeforeMigrate = func(ctx context.Context, _ []pgmigrate.Migration) error {
+			tx, err := db.BeginTx(ctx, nil)
+			assert.Nil(t, err)
+			_, err = inTx.MigrateTx(ctx, tx)
+			check.True(t, errors.Is(err, pgmigrate.ErrLockHeld))
+			return tx.Rollback()
+		}
+		_, err := migrator.Migrate(ctx, db)
+		return err
+	})
+	assert.Nil(t, err)
+}
+
+func TestOpsInTx(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = logger
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		marked, err := migrator.MarkApplied(ctx, tx, "0001_users")
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(marked))
+		plan, err := migrator.Plan(ctx, tx)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(plan))
+		assert.Nil(t, tx.Rollback())
+
+		plan, err = migrator.Plan(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(plan))
+		return nil
+	})
+	assert.Nil(t, err)
+}
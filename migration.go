@@ -1,7 +1,9 @@
 package pgmigrate
 
 import (
+	"context"
 	"crypto/md5"
+	"database/sql"
 	"fmt"
 	"path/filepath"
 	"sort"
@@ -9,26 +11,106 @@ import (
 	"time"
 )
 
-// Migration represents a single SQL migration.
+// MigrationFunc is the body of a Go migration, see [NewGoMigration].
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+// Migration represents a single migration, which is usually a SQL file but may
+// also be a Go function (see [NewGoMigration]).
 type Migration struct {
 	ID  string // the filename of the migration, without the .sql extension
 	SQL string // the contents of the migration file
+	// NoTransaction means the migration is applied outside of a transaction,
+	// which is necessary for statements like `CREATE INDEX CONCURRENTLY`.
+	// Each statement in the migration is executed separately, and the record
+	// of the migration is only written after all of them have succeeded.
+	//
+	// [Load] sets this for files with a `-- pgmigrate:no-transaction` header.
+	NoTransaction bool
+	// LockTimeout and StatementTimeout, if non-zero, override the
+	// [Migrator]'s settings of the same name for this migration.
+	//
+	// [Load] sets these for files with `-- pgmigrate:lock_timeout 5s` or
+	// `-- pgmigrate:statement_timeout 1m` headers.
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+	// Template means the migration's SQL is a [text/template] that is
+	// rendered with the [Migrator.Vars] before it is applied, see
+	// [Migrator.Render]. The checksum of the migration is calculated from the
+	// unrendered template, so that rendering it with different variables in
+	// different environments does not cause verification errors.
+	//
+	// [Load] sets this for files with a `-- pgmigrate:template` header.
+	Template bool
+	// Func, if set, makes this a Go migration: instead of executing SQL, the
+	// [Migrator] calls Func with the transaction that the migration is
+	// applied in. See [NewGoMigration].
+	Func MigrationFunc
+	// FuncChecksum is the checksum of a Go migration. Because there is no SQL
+	// to hash, it must be declared explicitly; change it whenever the
+	// behavior of Func changes.
+	FuncChecksum string
+}
+
+// NewGoMigration returns a [Migration] that is applied by calling fn inside of
+// the migration's transaction, rather than by executing SQL. Go migrations are
+// ordered by ID, planned, recorded, and verified exactly like SQL migrations,
+// so they can be mixed freely with the migrations returned by [Load]:
+//
+//	migrations, err := pgmigrate.Load(migrationsFS)
+//	// handle err
+//	migrations = append(migrations, pgmigrate.NewGoMigration(
+//		"0042_reencode_avatars",
+//		"reencode-avatars-v1",
+//		reencodeAvatars,
+//	))
+//	migrator := pgmigrate.NewMigrator(migrations)
+//
+// The checksum is recorded in the migrations table in place of the checksum
+// of the SQL, and [Migrator.Verify] warns if it changes after the migration was applied.
+func NewGoMigration(id string, checksum string, fn MigrationFunc) Migration {
+	return Migration{
+		ID:           id,
+		Func:         fn,
+		FuncChecksum: checksum,
+	}
 }
 
 // MD5 computes the MD5 hash of the SQL for this migration so that it can be
-// uniquely identified. After a Migration is applied, the [AppliedMigration]
-// will store this hash in the `Checksum` field.
+// uniquely identified. When the [Migrator.ChecksumAlgorithm] is [ChecksumMD5],
+// the default, the [AppliedMigration] will store this hash in the `Checksum`
+// field after the Migration is applied.
 func (m *Migration) MD5() string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(m.SQL)))
 }
 
+// validate returns an error if the migration cannot be applied.
+func (m *Migration) validate() error {
+	if m.Func == nil {
+		return nil
+	}
+	if m.FuncChecksum == "" {
+		return fmt.Errorf("%s: go migration must declare a checksum", m.ID)
+	}
+	if m.NoTransaction {
+		return fmt.Errorf("%s: go migration cannot be applied without a transaction", m.ID)
+	}
+	return nil
+}
+
 // AppliedMigration represents a successfully-executed [Migration]. It embeds
 // the [Migration], and adds fields for execution results.
 type AppliedMigration struct {
 	Migration
-	Checksum              string    // The MD5 hash of the SQL of this migration
+	Checksum              string    // The checksum of the SQL of this migration (see [ChecksumAlgorithm]), or the checksum of a Go migration
 	ExecutionTimeInMillis int64     // How long it took to run this migration
 	AppliedAt             time.Time // When the migration was run
+	// The following fields are empty for migrations that were applied before
+	// pgmigrate started recording them.
+	AppliedBy        string // The Postgres user (current_user) that applied the migration
+	Hostname         string // The hostname of the machine that applied the migration
+	ApplicationName  string // The application_name of the connection that applied the migration
+	PgmigrateVersion string // The version of pgmigrate that applied the migration
+	AppliedSQL       string // The SQL that was applied (the unrendered template for templated migrations), empty for Go migrations and migrations marked as applied
 }
 
 // IDFromFilename removes directory paths and extensions from the filename to
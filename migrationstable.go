@@ -0,0 +1,181 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+	"github.com/peterldowns/pgmigrate/internal/sessionlock"
+)
+
+// currentMigrationsTableVersion is the current version of the schema of the
+// migrations table. Whenever columns are added to the migrations table, the
+// version is incremented and new entries are appended to
+// migrationsTableUpgrades and migrationsTableVersionColumns.
+const currentMigrationsTableVersion = 2
+
+// migrationsTableUpgrades are the queries that upgrade the migrations table
+// from one version to the next: the query at index i upgrades the table from
+// version i+1 to version i+2. Version 1 is the original schema created by
+// ensureMigrationsTable. Each query is formatted with the quoted name of the
+// migrations table.
+//
+//nolint:gochecknoglobals
+var migrationsTableUpgrades = []string{
+	// version 2: record who applied each migration, and what was applied.
+	`ALTER TABLE %s
+		ADD COLUMN IF NOT EXISTS applied_by TEXT,
+		ADD COLUMN IF NOT EXISTS hostname TEXT,
+		ADD COLUMN IF NOT EXISTS application_name TEXT,
+		ADD COLUMN IF NOT EXISTS pgmigrate_version TEXT,
+		ADD COLUMN IF NOT EXISTS applied_sql TEXT`,
+}
+
+// migrationsTableVersionColumns identify the version of the schema of a
+// migrations table: the column at index i is added by the upgrade to version
+// i+2, so a table that has it is at least at that version. A table that has
+// none of them is at version 1.
+//
+//nolint:gochecknoglobals
+var migrationsTableVersionColumns = []string{
+	// version 2: added last by the upgrade, in the same statement as the
+	// other columns.
+	"applied_sql",
+}
+
+// upgradeMigrationsTable brings the schema of an existing migrations table up
+// to date by applying any upgrades that it is missing.
+//
+// The upgrades are applied in a transaction that holds a transaction-scoped
+// advisory lock with the same ID as the lock used by [Migrator.Migrate], so
+// they never run at the same time as another upgrade or as a migration being
+// applied by a different session. If db is already a transaction, the
+// upgrades are applied as part of it.
+func (m *Migrator) upgradeMigrationsTable(ctx context.Context, db Querier) error {
+	version, err := m.migrationsTableVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version >= currentMigrationsTableVersion {
+		return nil
+	}
+	upgrade := func(tx Querier) error {
+		query := `SELECT pg_advisory_xact_lock($1)`
+		if _, err := m.execContext(ctx, tx, query, sessionlock.ID(m.lockName())); err != nil {
+			return fmt.Errorf("upgradeMigrationsTable/lock: %w", err)
+		}
+		// Another session may have upgraded the table while this one was
+		// waiting for the lock.
+		version, err := m.migrationsTableVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+		table := pgtools.Identifier(m.TableName)
+		for ; version < currentMigrationsTableVersion; version++ {
+			m.info(ctx, "upgrading migrations table",
+				LogField{Key: "table_name", Value: m.TableName},
+				LogField{Key: "from_version", Value: version},
+				LogField{Key: "to_version", Value: version + 1},
+			)
+			query := fmt.Sprintf(migrationsTableUpgrades[version-1], table)
+			if _, err := m.execContext(ctx, tx, query); err != nil {
+				return fmt.Errorf("upgradeMigrationsTable/version %d: %w", version+1, err)
+			}
+		}
+		return nil
+	}
+	if executor, ok := db.(Executor); ok {
+		return m.inTx(ctx, executor, func(tx *sql.Tx) error { return upgrade(tx) })
+	}
+	return upgrade(db)
+}
+
+// migrationsTableVersion returns the version of the schema of the migrations
+// table, which must exist, based on which of migrationsTableVersionColumns it
+// has.
+func (m *Migrator) migrationsTableVersion(ctx context.Context, db Querier) (int, error) {
+	schema, tablename := pgtools.ParseTableName(m.TableName)
+	query := `
+		SELECT attname::text
+		FROM pg_attribute
+		WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped`
+	rows, err := m.queryContext(ctx, db, query, pgtools.Identifier(schema+"."+tablename))
+	if err != nil {
+		return 0, fmt.Errorf("migrationsTableVersion: %w", err)
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return 0, fmt.Errorf("migrationsTableVersion: %w", err)
+		}
+		columns[column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("migrationsTableVersion: %w", err)
+	}
+	version := 1
+	for i, column := range migrationsTableVersionColumns {
+		if columns[column] {
+			version = i + 2
+		}
+	}
+	return version, nil
+}
+
+// appliedColumns returns the columns to select from a migrations table at the
+// given version, in the order expected by scanAppliedMigrations. Columns that
+// do not exist yet at that version are selected as NULL.
+func appliedColumns(version int) string {
+	columns := `id, checksum, execution_time_in_millis, applied_at`
+	if version >= 2 {
+		return columns + `, applied_by, hostname, application_name, pgmigrate_version, applied_sql`
+	}
+	return columns + `, NULL, NULL, NULL, NULL, NULL`
+}
+
+// hostname returns the hostname of the machine running pgmigrate, or an empty
+// string if it cannot be determined.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// pgmigrateVersion returns the version of the pgmigrate module that is
+// running, as recorded in the build information of the current binary. It
+// returns "(devel)" when pgmigrate is built from a local checkout, and
+// "unknown" if there is no build information.
+//
+//nolint:gochecknoglobals
+var pgmigrateVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	const path = "github.com/peterldowns/pgmigrate"
+	if info.Main.Path == path {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+})
+
+// nullString converts an empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
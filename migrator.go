@@ -3,7 +3,10 @@ package pgmigrate
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/peterldowns/pgmigrate/internal/multierr"
@@ -16,10 +19,22 @@ const (
 	// schema) that pgmigrate will use to store a record of applied migrations.
 	DefaultTableName string = "public.pgmigrate_migrations"
 
-	// sessionLockPrefix is prefix used by pgmigrate to help prevent conflicts
-	// between its lock and other users of Postgres advisory locks. This prefix
-	// is used to construct a lock name which is then hashed to an integer.
-	sessionLockPrefix string = "pgmigrate-"
+	// DefaultLockRetryBackoff is the default amount of time to wait before
+	// retrying a migration that failed to acquire a lock.
+	DefaultLockRetryBackoff time.Duration = time.Second
+
+	// DefaultLockWaitLogInterval is the default amount of time between the
+	// messages that [Migrator.Migrate] logs about the holder of the
+	// migrations lock while it waits to acquire it.
+	DefaultLockWaitLogInterval time.Duration = 10 * time.Second
+
+	// DefaultLockNamespace is the default namespace of the advisory lock that
+	// pgmigrate holds while it applies migrations.
+	DefaultLockNamespace string = "pgmigrate"
+
+	// DefaultLockSpinInterval is the default amount of time to wait between
+	// attempts to acquire the migrations lock while another session holds it.
+	DefaultLockSpinInterval time.Duration = sessionlock.SpinWait
 )
 
 // Executor is satisfied by *sql.DB as well as *sql.Conn. Many of the Migrator's
@@ -27,10 +42,34 @@ const (
 // running queries on a *sql.Conn. These methods accept an Executor so that they
 // can more easily be used by an external caller.
 type Executor interface {
+	Querier
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Querier is satisfied by *sql.DB, *sql.Conn, and *sql.Tx. Methods that accept
+// a Querier can also be called with a transaction that you control, in which
+// case any changes that they make are done inside of a savepoint, and are
+// only committed when you commit the transaction. See [Migrator.MigrateTx].
+type Querier interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
-	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// execer is satisfied by *sql.DB, *sql.Conn, and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queryer is satisfied by *sql.DB, *sql.Conn, and *sql.Tx.
+type queryer interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// rowsQueryer is satisfied by *sql.DB, *sql.Conn, and *sql.Tx.
+type rowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 }
 
 // Migrator should be instantiated with [NewMigrator] rather than used directly.
@@ -50,6 +89,168 @@ type Migrator struct {
 	//
 	// [NewMigrator] defaults it to [DefaultTableName].
 	TableName string
+	// LockTimeout and StatementTimeout, if non-zero, are used to set the
+	// `lock_timeout` and `statement_timeout` Postgres settings while each
+	// migration is applied. A migration can override these values with its
+	// own [Migration.LockTimeout] and [Migration.StatementTimeout]. Postgres
+	// timeouts have millisecond precision, so they are rounded up to the next
+	// millisecond.
+	//
+	// [NewMigrator] defaults them to 0, which leaves the settings unchanged.
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+	// LockRetryMaxAttempts is the maximum number of times a migration will be
+	// attempted if it fails because it could not acquire a lock in time
+	// (SQLSTATE 55P03, lock_not_available). Any other kind of failure is never
+	// retried. A retry of a [Migration.NoTransaction] migration resumes from
+	// the statement that failed, since the statements before it have already
+	// been committed.
+	//
+	// [NewMigrator] defaults it to 1, which means no retries.
+	LockRetryMaxAttempts int
+	// LockRetryBackoff is how long to wait before the first retry of a
+	// migration that could not acquire a lock. The wait doubles after each
+	// subsequent attempt.
+	//
+	// [NewMigrator] defaults it to [DefaultLockRetryBackoff].
+	LockRetryBackoff time.Duration
+	// LockWaitLogInterval is how often [Migrator.Migrate] logs the details of
+	// the session holding the migrations lock, see [LockHolder], while it
+	// waits to acquire the lock. If it is 0, nothing is logged while waiting.
+	//
+	// [NewMigrator] defaults it to [DefaultLockWaitLogInterval].
+	LockWaitLogInterval time.Duration
+	// LockMaxWait, if non-zero, is the longest that [Migrator.Migrate] waits
+	// to acquire the migrations lock before it gives up with [ErrLockHeld].
+	//
+	// [NewMigrator] defaults it to 0, which waits until the context is done.
+	LockMaxWait time.Duration
+	// LockNoWait, if true, makes [Migrator.Migrate] give up with
+	// [ErrLockHeld] immediately if another session holds the migrations lock.
+	//
+	// [NewMigrator] defaults it to false.
+	LockNoWait bool
+	// LockSpinInterval is how long [Migrator.Migrate] waits between attempts
+	// to acquire the migrations lock while another session holds it.
+	//
+	// [NewMigrator] defaults it to [DefaultLockSpinInterval].
+	LockSpinInterval time.Duration
+	// LockNamespace and [Migrator.TableName] together name the advisory lock
+	// that [Migrator.Migrate] holds while it applies migrations, which is
+	// hashed to a 64-bit advisory lock key. Set it to keep the lock from
+	// colliding with another application's advisory locks, or to give
+	// separate sets of migrations that share a migrations table their own
+	// locks. Every migrator that applies migrations to the same table must use
+	// the same namespace.
+	//
+	// [NewMigrator] defaults it to [DefaultLockNamespace].
+	LockNamespace string
+	// Locker is how [Migrator.Migrate] makes sure that only one migrator
+	// applies migrations at a time, see [Locker]. Use a [LeaseLocker] if the
+	// database is reached through a connection pooler like PgBouncer in
+	// transaction pooling mode. Every migrator that applies migrations to the
+	// same table must use the same kind of Locker.
+	//
+	// [NewMigrator] defaults it to a [SessionLocker].
+	Locker Locker
+	// SingleTransaction, if true, makes [Migrator.Migrate] apply the entire
+	// plan, including the records marking each migration as applied, inside
+	// of a single transaction. If any migration fails, none of them are
+	// applied. Migrate refuses to start if the plan contains a
+	// [Migration.NoTransaction] migration. If the transaction fails because it
+	// could not acquire a lock, the whole transaction is retried.
+	//
+	// [NewMigrator] defaults it to false, and each migration is applied in
+	// its own transaction.
+	SingleTransaction bool
+	// StrictOrdering, if true, forbids applying migrations "out of order".
+	// If any unapplied migration sorts before the latest applied migration,
+	// [Migrator.Plan] and [Migrator.Migrate] fail with an [OutOfOrderError],
+	// and [Migrator.Verify] reports a [VerificationOutOfOrder] error for each
+	// of those migrations.
+	//
+	// [NewMigrator] defaults it to false, and out-of-order migrations are
+	// applied normally.
+	StrictOrdering bool
+	// CompareIDs orders migrations by their IDs, returning a negative number
+	// if a sorts before b, a positive number if a sorts after b, and 0 if
+	// they are the same. The plan is applied in this order, and
+	// [Migrator.StrictOrdering] and [Migrator.MigrateTo] use it to decide
+	// which migrations come before others. Set it to [Sources.CompareIDs] to
+	// apply migrations from several sources in the right order.
+	//
+	// [NewMigrator] defaults it to [strings.Compare], which orders migrations
+	// the same way as [SortByID].
+	CompareIDs func(a, b string) int
+	// ChecksumAlgorithm is how the checksum of each migration is calculated
+	// when it is applied. Records written with a different algorithm are
+	// still verified with the algorithm that wrote them, and once they are
+	// verified, [Migrator.Migrate] rewrites them to use this algorithm.
+	//
+	// [NewMigrator] defaults it to [ChecksumMD5].
+	ChecksumAlgorithm ChecksumAlgorithm
+	// Vars are the variables that migrations marked as [Migration.Template]
+	// are rendered with, available as `{{ .Vars.name }}`.
+	//
+	// [NewMigrator] defaults it to `nil`, and templates that refer to any
+	// variable fail to render.
+	Vars map[string]string
+	// Tenants and TenantsQuery list the Postgres schemas that
+	// [Migrator.MigrateTenants] applies the migrations to, one schema per
+	// tenant. TenantsQuery is a query that returns a single column of schema
+	// names, like `SELECT nspname FROM pg_namespace WHERE nspname LIKE
+	// 'tenant_%'`. If both are set, the schemas from both are migrated.
+	//
+	// [NewMigrator] defaults them to empty, and there are no tenants.
+	Tenants      []string
+	TenantsQuery string
+	// TenantConcurrency is the maximum number of tenants that
+	// [Migrator.MigrateTenants] migrates at the same time. Each tenant that is
+	// being migrated uses its own connection.
+	//
+	// [NewMigrator] defaults it to 1, and tenants are migrated one at a time.
+	TenantConcurrency int
+	// HistoryTableName, if set, is the table that this migrator appends a
+	// record of every change it makes to: every attempt to apply a migration,
+	// whether it succeeded or failed, and every change made with the ops
+	// methods, like [Migrator.MarkApplied]. The table is created if it does
+	// not exist, and can be read with [Migrator.History].
+	//
+	// Attempts to apply a migration are recorded outside of the migration's
+	// transaction, so that failures are recorded too; if recording one fails,
+	// the failure is logged but does not change the outcome of the migration.
+	// Changes made with the ops methods are recorded in the same transaction
+	// as the change.
+	//
+	// [NewMigrator] defaults it to empty, and no history is recorded. Use
+	// [DefaultHistoryTableName] for the conventional name.
+	HistoryTableName string
+	// Hooks are optional callbacks that are called while migrations are
+	// applied, see [Hooks] for details.
+	//
+	// [NewMigrator] defaults them all to `nil`.
+	Hooks Hooks
+	// OnEvent, if set, is called with each [Event] while migrations are
+	// applied, like [MigrationStarted] and [MigrationSucceeded], so that you
+	// can report progress or record metrics; see [Metrics] for a ready-made
+	// adapter. It is called synchronously, so it should return quickly, and
+	// it may be called concurrently by [Migrator.MigrateTenants].
+	//
+	// [NewMigrator] defaults it to `nil`, and no events are sent.
+	OnEvent func(ctx context.Context, event Event)
+	// Tracer, if set, traces each run of [Migrator.Migrate]: a span for the
+	// whole run, with child spans for acquiring the migrations lock,
+	// calculating the plan, applying each migration, and verifying the
+	// result. A failed span records its error, along with its SQLSTATE if the
+	// error came from Postgres. See the otelmigrate package for a Tracer that
+	// uses OpenTelemetry.
+	//
+	// [NewMigrator] defaults it to `nil`, and nothing is traced.
+	Tracer Tracer
+
+	// tenant is the schema of the tenant that this Migrator applies
+	// migrations to, see [Migrator.MigrateTenants].
+	tenant string
 }
 
 // NewMigrator creates a [Migrator] and sets appropriate default values for all
@@ -57,15 +258,55 @@ type Migrator struct {
 //
 //   - Logger: `nil`, no messages will be logged
 //   - TableName: [DefaultTableName]
+//   - LockTimeout: 0, the `lock_timeout` setting is not changed
+//   - StatementTimeout: 0, the `statement_timeout` setting is not changed
+//   - LockRetryMaxAttempts: 1, migrations are not retried
+//   - LockRetryBackoff: [DefaultLockRetryBackoff]
+//   - LockWaitLogInterval: [DefaultLockWaitLogInterval]
+//   - LockMaxWait: 0, wait for the migrations lock until the context is done
+//   - LockNoWait: false
+//   - LockSpinInterval: [DefaultLockSpinInterval]
+//   - LockNamespace: [DefaultLockNamespace]
+//   - Locker: [SessionLocker]
+//   - SingleTransaction: false, each migration has its own transaction
+//   - StrictOrdering: false, migrations may be applied out of order
+//   - CompareIDs: [strings.Compare], migrations are ordered by ID
+//   - ChecksumAlgorithm: [ChecksumMD5]
+//   - Vars: `nil`, no template variables
+//   - Tenants, TenantsQuery: empty, there are no tenants
+//   - TenantConcurrency: 1, tenants are migrated one at a time
+//   - HistoryTableName: empty, no history is recorded
+//   - Hooks: none
+//   - OnEvent: `nil`, no events are sent
+//   - Tracer: `nil`, nothing is traced
 //
 // To configure these fields, just set the values on the struct.
 func NewMigrator(
 	migrations []Migration,
 ) *Migrator {
 	return &Migrator{
-		Migrations: migrations,
-		Logger:     nil,
-		TableName:  DefaultTableName,
+		Migrations:           migrations,
+		Logger:               nil,
+		TableName:            DefaultTableName,
+		LockTimeout:          0,
+		StatementTimeout:     0,
+		LockRetryMaxAttempts: 1,
+		LockRetryBackoff:     DefaultLockRetryBackoff,
+		LockWaitLogInterval:  DefaultLockWaitLogInterval,
+		LockMaxWait:          0,
+		LockNoWait:           false,
+		LockSpinInterval:     DefaultLockSpinInterval,
+		LockNamespace:        DefaultLockNamespace,
+		Locker:               SessionLocker{},
+		SingleTransaction:    false,
+		StrictOrdering:       false,
+		CompareIDs:           strings.Compare,
+		ChecksumAlgorithm:    ChecksumMD5,
+		Vars:                 nil,
+		Tenants:              nil,
+		TenantsQuery:         "",
+		TenantConcurrency:    1,
+		HistoryTableName:     "",
 	}
 }
 
@@ -76,6 +317,14 @@ func NewMigrator(
 //   - checksum: text not null
 //   - execution_time_in_millis: integer not null
 //   - applied_at: timestamp with time zone not null
+//   - applied_by: text, the Postgres user (current_user) that applied it
+//   - hostname: text, the hostname of the machine that applied it
+//   - application_name: text, the application_name of the connection
+//   - pgmigrate_version: text, the version of pgmigrate that applied it
+//   - applied_sql: text, the SQL that was applied, or the template of a templated migration
+//
+// An existing migrations table without the newer columns is upgraded in
+// place while the advisory lock is held.
 //
 // It does the following things:
 //
@@ -100,52 +349,201 @@ func NewMigrator(
 // which means that future attempts to run the migrations will include it in
 // their plan.
 //
+// Migrations marked as [Migration.NoTransaction] are the exception: each of
+// their statements is run directly on the locked connection, and the record is
+// only created once all of them have succeeded. If one of these migrations
+// fails partway through, any statements that already succeeded are NOT rolled
+// back, so these migrations should be written to be safe to re-run.
+//
 // Migrate() will immediately return the error related to a failed migration,
 // and will NOT attempt to run any further migrations. Any migrations applied
 // before the failure will remain applied. Any migrations not yet applied will
 // not be attempted.
 //
+// If [Migrator.SingleTransaction] is set, the entire plan is instead applied
+// in one transaction, so a failed migration also rolls back every migration
+// before it in the plan.
+//
 // If all the migrations in the plan are applied successfully, then call Verify()
 // to double-check that all known migrations have been marked as applied in the
 // migrations table.
 //
 // Finally, the advisory lock is released.
 func (m *Migrator) Migrate(ctx context.Context, db *sql.DB) ([]VerificationError, error) {
+	_, verrs, err := m.migrateTo(ctx, db, "")
+	return verrs, err
+}
+
+// MigrateTo is like [Migrator.Migrate], but only applies the migrations in the
+// plan whose IDs are less than or equal to target, the ID of a known
+// migration. This is useful for bringing a database to the exact state before
+// or after a specific migration.
+//
+// If there is no migration with the target ID, MigrateTo returns an error
+// without applying any migrations.
+func (m *Migrator) MigrateTo(ctx context.Context, db *sql.DB, target string) ([]VerificationError, error) {
+	if err := m.checkTarget(target); err != nil {
+		return nil, err
+	}
+	_, verrs, err := m.migrateTo(ctx, db, target)
+	return verrs, err
+}
+
+// migrateTo acquires the advisory lock and applies the plan, limited to
+// target if it is not empty. It returns the migrations that were applied.
+func (m *Migrator) migrateTo(ctx context.Context, db *sql.DB, target string) ([]AppliedMigration, []VerificationError, error) {
+	ctx, span := m.startSpan(ctx, SpanMigrate, LogField{Key: "table_name", Value: m.TableName})
+	if target != "" {
+		span.SetAttributes(LogField{Key: "target", Value: target})
+	}
+	var applied []AppliedMigration
 	var verrs []VerificationError
-	lockName := fmt.Sprintf("%s-%s", sessionLockPrefix, m.TableName)
-	return verrs, sessionlock.With(ctx, db, lockName, func(conn *sql.Conn) error {
-		err := m.ensureMigrationsTable(ctx, conn)
+	lockStart := time.Now()
+	// The lock span is not added to the context, so that the spans started
+	// while the lock is held are children of the run's span.
+	_, lockSpan := m.startSpan(ctx, SpanAcquireLock, LogField{Key: "lock_name", Value: m.lockName()})
+	locked := false
+	err := m.locker().WithLock(ctx, db, m.lockName(), m.lockOptions(), func(ctx context.Context, conn *sql.Conn) error {
+		locked = true
+		endSpan(lockSpan, nil)
+		m.emit(ctx, LockAcquired{Waited: time.Since(lockStart)})
+		var err error
+		applied, verrs, err = m.migrateLocked(ctx, db, conn, target)
+		return err
+	})
+	if !locked {
+		endSpan(lockSpan, err)
+	}
+	if errors.Is(err, ErrLockHeld) {
+		m.logLockHolders(ctx, db)
+	}
+	span.SetAttributes(LogField{Key: "applied", Value: len(applied)})
+	endSpan(span, err)
+	return applied, verrs, err
+}
+
+// migrateLocked applies the plan once the migrations lock has been acquired,
+// using the tenant's schema if there is one, and then calls the AfterMigrate
+// hook.
+func (m *Migrator) migrateLocked(ctx context.Context, db, conn Querier, target string) (applied []AppliedMigration, verrs []VerificationError, final error) {
+	if m.tenant != "" {
+		// Unqualified names in the migrations refer to the tenant's schema.
+		restore, err := m.setSessionSettings(ctx, conn, []setting{
+			{name: "search_path", value: pgtools.Identifier(m.tenant)},
+		})
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		plan, err := m.Plan(ctx, conn)
-		if err != nil {
-			return err
+		defer func() { final = multierr.Join(final, restore()) }()
+	}
+	applied, verrs, err := m.migrate(ctx, db, conn, target)
+	if m.Hooks.AfterMigrate != nil {
+		if herr := m.Hooks.AfterMigrate(ctx, applied, err); herr != nil {
+			err = multierr.Join(err, fmt.Errorf("after migrate hook: %w", herr))
 		}
-		m.info(ctx, fmt.Sprintf("planning to apply %d migrations", len(plan)))
-		for i, migration := range plan {
-			m.debug(ctx, fmt.Sprintf("%d", i), LogField{Key: "migration_id", Value: migration.ID})
+	}
+	return applied, verrs, err
+}
+
+// migrate does the work of [Migrator.Migrate] once the advisory lock has been
+// acquired, returning the migrations that were applied along with any
+// verification errors. If target is not empty, the plan is limited to the
+// migrations up to and including target. The migrations are applied on conn,
+// which is either the connection holding the lock or the caller's transaction,
+// and verified on db.
+func (m *Migrator) migrate(ctx context.Context, db, conn Querier, target string) ([]AppliedMigration, []VerificationError, error) {
+	err := m.ensureMigrationsTable(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.ensureHistoryTable(ctx, conn); err != nil {
+		return nil, nil, err
+	}
+	planCtx, planSpan := m.startSpan(ctx, SpanPlan)
+	plan, err := m.Plan(planCtx, conn)
+	if err != nil {
+		endSpan(planSpan, err)
+		return nil, nil, err
+	}
+	if target != "" {
+		plan = m.limitPlan(plan, target)
+		m.info(ctx, "limiting plan to target migration", LogField{Key: "target", Value: target})
+	}
+	planSpan.SetAttributes(LogField{Key: "plan_size", Value: len(plan)})
+	endSpan(planSpan, nil)
+	m.info(ctx, fmt.Sprintf("planning to apply %d migrations", len(plan)))
+	m.emit(ctx, PlanComputed{Plan: plan})
+	for i, migration := range plan {
+		m.debug(ctx, fmt.Sprintf("%d", i), LogField{Key: "migration_id", Value: migration.ID})
+	}
+	// Render every templated migration before applying any of them, so that a
+	// missing variable cannot leave the plan half-applied.
+	var renderErrs []error
+	for _, migration := range plan {
+		_, err := m.Render(migration)
+		renderErrs = append(renderErrs, err)
+	}
+	if err := multierr.Join(renderErrs...); err != nil {
+		return nil, nil, fmt.Errorf("invalid templates: %w", err)
+	}
+	_, callerTx := conn.(*sql.Tx)
+	if m.SingleTransaction || callerTx {
+		var ids []string
+		for _, migration := range plan {
+			if migration.NoTransaction {
+				ids = append(ids, migration.ID)
+			}
+		}
+		where := "a single transaction"
+		if callerTx {
+			where = "the given transaction"
+		}
+		if len(ids) != 0 {
+			return nil, nil, fmt.Errorf("cannot apply migrations in %s, these migrations cannot run in a transaction: %s", where, strings.Join(ids, ", "))
+		}
+	}
+	if m.Hooks.BeforeMigrate != nil {
+		if err := m.Hooks.BeforeMigrate(ctx, plan); err != nil {
+			return nil, nil, fmt.Errorf("before migrate hook: %w", err)
 		}
+	}
+	var applied []AppliedMigration
+	if m.SingleTransaction && len(plan) != 0 {
+		applied, err = m.applyPlanInSingleTransaction(ctx, conn, plan)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
 		for _, migration := range plan {
-			err = m.applyMigration(ctx, conn, migration)
+			result, err := m.applyMigration(ctx, conn, migration)
 			if err != nil {
-				return err
+				return applied, nil, err
 			}
+			applied = append(applied, result)
 		}
-		m.info(ctx, "checking for verification errors")
-		verrs, err = m.Verify(ctx, db)
-		return err
-	})
+	}
+	if err := m.upgradeChecksums(ctx, conn); err != nil {
+		return applied, nil, err
+	}
+	m.info(ctx, "checking for verification errors")
+	verifyCtx, verifySpan := m.startSpan(ctx, SpanVerify)
+	verrs, err := m.Verify(verifyCtx, db)
+	verifySpan.SetAttributes(LogField{Key: "verification_errors", Value: len(verrs)})
+	endSpan(verifySpan, err)
+	if len(verrs) != 0 {
+		m.emit(ctx, VerificationIssues{Errors: verrs})
+	}
+	return applied, verrs, err
 }
 
-// ensureMigrationsTable will create the migrations table if it does not exist.
-func (m *Migrator) ensureMigrationsTable(ctx context.Context, db Executor) error {
+// ensureMigrationsTable will create the migrations table if it does not exist,
+// and upgrade its schema if it is out of date.
+func (m *Migrator) ensureMigrationsTable(ctx context.Context, db Querier) error {
 	m.info(ctx, "ensuring migrations table exists", LogField{Key: "table_name", Value: m.TableName})
 	schema, _ := pgtools.ParseTableName(m.TableName)
 	if schema != "" {
 		query := fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, pgtools.Identifier(schema))
-		m.debug(ctx, query)
-		_, err := db.ExecContext(ctx, query)
+		_, err := m.execContext(ctx, db, query)
 		if err != nil {
 			return fmt.Errorf("ensureMigrationsTable/create schema: %w", err)
 		}
@@ -158,17 +556,16 @@ func (m *Migrator) ensureMigrationsTable(ctx context.Context, db Executor) error
 					applied_at TIMESTAMPTZ NOT NULL
 				)
 			`, pgtools.Identifier(m.TableName))
-	m.debug(ctx, query)
-	_, err := db.ExecContext(ctx, query)
+	_, err := m.execContext(ctx, db, query)
 	if err != nil {
 		return fmt.Errorf("ensureMigrationsTable: %w", err)
 	}
-	return nil
+	return m.upgradeMigrationsTable(ctx, db)
 }
 
 // hasMigrationsTable returns true if the migrations table exists, false
 // otherwise.
-func (m *Migrator) hasMigrationsTable(ctx context.Context, db Executor) (bool, error) {
+func (m *Migrator) hasMigrationsTable(ctx context.Context, db Querier) (bool, error) {
 	schema, tablename := pgtools.ParseTableName(m.TableName)
 	query := fmt.Sprintf(`
 				SELECT EXISTS (
@@ -176,9 +573,8 @@ func (m *Migrator) hasMigrationsTable(ctx context.Context, db Executor) (bool, e
 					WHERE tablename = %s AND schemaname = %s
 				);
 			`, pgtools.Literal(tablename), pgtools.Literal(schema))
-	m.debug(ctx, query)
 	var exists bool
-	err := db.QueryRowContext(ctx, query).Scan(&exists)
+	err := m.queryRowContext(ctx, db, query).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("hasMigrationsTable: %w", err)
 	}
@@ -224,12 +620,37 @@ func (m *Migrator) hasMigrationsTable(ctx context.Context, db Executor) (bool, e
 // of the time, when you're working with your coworkers, you will not write
 // migrations that conflict with each other. As long as you use a migration
 // name/number higher than that of any dependencies, you will not have any
-// problems.
-func (m *Migrator) Plan(ctx context.Context, db Executor) ([]Migration, error) {
+// problems. If you'd rather forbid this, set [Migrator.StrictOrdering] and
+// Plan will return an [OutOfOrderError] instead.
+func (m *Migrator) Plan(ctx context.Context, db Querier) ([]Migration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	applied, err := m.Applied(ctx, db)
 	if err != nil {
 		return nil, err
 	}
+	plan := m.pending(applied)
+	if m.StrictOrdering {
+		latest, outOfOrder := m.findOutOfOrder(plan, applied)
+		if len(outOfOrder) != 0 {
+			err := &OutOfOrderError{LatestApplied: latest}
+			for _, migration := range outOfOrder {
+				err.IDs = append(err.IDs, migration.ID)
+			}
+			m.error(ctx, err, "found out-of-order migrations",
+				LogField{Key: "migration_ids", Value: err.IDs},
+				LogField{Key: "latest_applied_migration_id", Value: latest},
+			)
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// pending returns the known migrations that have not been applied, sorted by
+// their IDs.
+func (m *Migrator) pending(applied []AppliedMigration) []Migration {
 	appliedMap := map[string]AppliedMigration{}
 	for _, m := range applied {
 		appliedMap[m.ID] = m
@@ -241,8 +662,94 @@ func (m *Migrator) Plan(ctx context.Context, db Executor) ([]Migration, error) {
 			plan = append(plan, migration)
 		}
 	}
-	SortByID(plan)
-	return plan, nil
+	compare := m.compareIDs()
+	sort.SliceStable(plan, func(i, j int) bool {
+		return compare(plan[i].ID, plan[j].ID) < 0
+	})
+	return plan
+}
+
+// compareIDs returns [Migrator.CompareIDs], or [strings.Compare] if it is not
+// set.
+func (m *Migrator) compareIDs() func(a, b string) int {
+	if m.CompareIDs == nil {
+		return strings.Compare
+	}
+	return m.CompareIDs
+}
+
+// findOutOfOrder returns the ID of the latest applied migration, in sort
+// order, and the pending migrations whose IDs sort before it.
+func (m *Migrator) findOutOfOrder(pending []Migration, applied []AppliedMigration) (string, []Migration) {
+	compare := m.compareIDs()
+	var latest string
+	for _, migration := range applied {
+		if latest == "" || compare(migration.ID, latest) > 0 {
+			latest = migration.ID
+		}
+	}
+	var outOfOrder []Migration
+	for _, migration := range pending {
+		if latest != "" && compare(migration.ID, latest) < 0 {
+			outOfOrder = append(outOfOrder, migration)
+		}
+	}
+	return latest, outOfOrder
+}
+
+// OutOfOrderError is returned by [Migrator.Plan], and therefore by
+// [Migrator.Migrate], when [Migrator.StrictOrdering] is set and there are
+// unapplied migrations that sort before the latest applied migration.
+type OutOfOrderError struct {
+	// IDs are the IDs of the out-of-order migrations, in sorted order.
+	IDs []string
+	// LatestApplied is the ID of the applied migration that sorts last.
+	LatestApplied string
+}
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf(
+		"found migrations that sort before the latest applied migration %q: %s",
+		e.LatestApplied, strings.Join(e.IDs, ", "),
+	)
+}
+
+// PlanTo is like [Migrator.Plan], but only includes the migrations whose IDs
+// are less than or equal to target, the ID of a known migration. It shows
+// which migrations [Migrator.MigrateTo] would apply.
+//
+// If there is no migration with the target ID, PlanTo returns an error.
+func (m *Migrator) PlanTo(ctx context.Context, db Querier, target string) ([]Migration, error) {
+	if err := m.checkTarget(target); err != nil {
+		return nil, err
+	}
+	plan, err := m.Plan(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return m.limitPlan(plan, target), nil
+}
+
+// checkTarget returns an error if there is no migration with the target ID.
+func (m *Migrator) checkTarget(target string) error {
+	for _, migration := range m.Migrations {
+		if migration.ID == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown target migration %q", target)
+}
+
+// limitPlan returns the migrations in the plan whose IDs sort before or are
+// equal to target. The plan must already be sorted, see [Migrator.CompareIDs].
+func (m *Migrator) limitPlan(plan []Migration, target string) []Migration {
+	compare := m.compareIDs()
+	for i, migration := range plan {
+		if compare(migration.ID, target) > 0 {
+			return plan[:i]
+		}
+	}
+	return plan
 }
 
 // Applied returns a list of [AppliedMigration]s in the order that they were
@@ -250,7 +757,7 @@ func (m *Migrator) Plan(ctx context.Context, db Executor) ([]Migration, error) {
 //
 // If there are no applied migrations, or the specified table does not exist,
 // this will return an empty list without an error.
-func (m *Migrator) Applied(ctx context.Context, db Executor) ([]AppliedMigration, error) {
+func (m *Migrator) Applied(ctx context.Context, db Querier) ([]AppliedMigration, error) {
 	hasMigrations, err := m.hasMigrationsTable(ctx, db)
 	if err != nil {
 		return nil, err
@@ -258,20 +765,52 @@ func (m *Migrator) Applied(ctx context.Context, db Executor) ([]AppliedMigration
 	if !hasMigrations {
 		return nil, nil
 	}
+	// The table may not have been upgraded yet, in which case the newer
+	// columns are left empty.
+	version, err := m.migrationsTableVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
 	query := fmt.Sprintf(`
-		SELECT id, checksum, execution_time_in_millis, applied_at
+		SELECT %s
 		FROM %s ORDER BY applied_at, id ASC
-	`, pgtools.Identifier(m.TableName))
-	m.debug(ctx, query)
-	rows, err := db.QueryContext(ctx, query)
+	`, appliedColumns(version), pgtools.Identifier(m.TableName))
+	rows, err := m.queryContext(ctx, db, query)
 	if err != nil {
 		return nil, err
 	}
 	return scanAppliedMigrations(rows)
 }
 
-func (m *Migrator) inTx(ctx context.Context, db Executor, cb func(tx *sql.Tx) error) (final error) {
-	tx, err := db.BeginTx(ctx, nil)
+// validate returns an error describing every migration that cannot be applied,
+// such as a Go migration without a checksum.
+func (m *Migrator) validate() error {
+	if err := m.ChecksumAlgorithm.validate(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, migration := range m.Migrations {
+		errs = append(errs, migration.validate())
+	}
+	if err := multierr.Join(errs...); err != nil {
+		return fmt.Errorf("invalid migrations: %w", err)
+	}
+	return nil
+}
+
+// inTx calls cb inside of a new transaction, which is committed if cb succeeds
+// and rolled back otherwise. If db is already a transaction, cb is instead
+// called inside of a savepoint, which is released if cb succeeds and rolled
+// back to otherwise, so that the transaction can still be used after cb fails.
+func (m *Migrator) inTx(ctx context.Context, db Querier, cb func(tx *sql.Tx) error) (final error) {
+	if tx, ok := db.(*sql.Tx); ok {
+		return m.inSavepoint(ctx, tx, cb)
+	}
+	executor, ok := db.(Executor)
+	if !ok {
+		return fmt.Errorf("tx open: cannot begin a transaction on a %T", db)
+	}
+	tx, err := executor.BeginTx(ctx, nil)
 	if err != nil {
 		msg := "tx open"
 		m.error(ctx, err, msg)
@@ -291,59 +830,516 @@ func (m *Migrator) inTx(ctx context.Context, db Executor, cb func(tx *sql.Tx) er
 	return cb(tx)
 }
 
-// applyMigration runs a single migration inside a transaction:
+// applyMigration applies a single migration, retrying it if it fails because it
+// could not acquire a lock in time and the [Migrator] is configured to allow
+// retries. Each attempt is recorded in the history table, if there is one.
+func (m *Migrator) applyMigration(ctx context.Context, db Querier, migration Migration) (applied AppliedMigration, err error) {
+	ctx, span := m.startMigrationSpan(ctx, migration)
+	attempt := 0
+	// The statements of a no-transaction migration are committed one at a
+	// time, so a retry resumes from the statement that failed instead of
+	// running the ones before it again.
+	executed := 0
+	defer func() {
+		span.SetAttributes(LogField{Key: "attempts", Value: attempt})
+		endSpan(span, err)
+	}()
+	err = m.retryOnLockTimeout(ctx, func() (err error) {
+		attempt++
+		m.emit(ctx, MigrationStarted{Migration: migration, Attempt: attempt})
+		startedAt := time.Now().UTC()
+		applied, err = m.attemptMigration(ctx, db, migration, &executed)
+		duration := time.Since(startedAt)
+		outcome := HistorySucceeded
+		if err != nil {
+			outcome = HistoryFailed
+			m.emit(ctx, MigrationFailed{Migration: migration, Attempt: attempt, Duration: duration, Err: err})
+		} else {
+			m.emit(ctx, MigrationSucceeded{Applied: applied, Duration: duration})
+		}
+		m.recordAttempt(ctx, db, migration, outcome, startedAt, duration.Milliseconds(), err)
+		return err
+	}, LogField{Key: "migration_id", Value: migration.ID})
+	if err != nil {
+		err = m.afterEachFailure(ctx, migration, err)
+	}
+	return applied, err
+}
+
+// retryOnLockTimeout calls attempt, and calls it again according to
+// [Migrator.LockRetryMaxAttempts] and [Migrator.LockRetryBackoff] if it fails
+// because it could not acquire a lock. Any fields are included in the log
+// message about each retry.
+func (m *Migrator) retryOnLockTimeout(ctx context.Context, attempt func() error, fields ...LogField) error {
+	backoff := m.LockRetryBackoff
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i >= m.LockRetryMaxAttempts || pgtools.ErrorCode(err) != pgtools.LockNotAvailable {
+			return err
+		}
+		m.warn(ctx, "could not acquire a lock, retrying", append(fields,
+			LogField{Key: "attempt", Value: i},
+			LogField{Key: "max_attempts", Value: m.LockRetryMaxAttempts},
+			LogField{Key: "backoff", Value: backoff},
+		)...)
+		select {
+		case <-ctx.Done():
+			return multierr.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// applyPlanInSingleTransaction applies every migration in the plan, and
+// inserts the records marking them as applied, inside of one transaction. If
+// any of them fails, all of them are rolled back. If the transaction fails
+// because it could not acquire a lock, the whole transaction is retried. Each
+// attempt is recorded in the history table, if there is one.
+func (m *Migrator) applyPlanInSingleTransaction(ctx context.Context, db Querier, plan []Migration) ([]AppliedMigration, error) {
+	var applied []AppliedMigration
+	var failed *Migration
+	m.info(ctx, "applying migrations in a single transaction")
+	attempt := 0
+	err := m.retryOnLockTimeout(ctx, func() error {
+		attempt++
+		applied, failed = nil, nil
+		var failedAt time.Time
+		err := m.inTx(ctx, db, func(tx *sql.Tx) error {
+			for _, migration := range plan {
+				m.emit(ctx, MigrationStarted{Migration: migration, Attempt: attempt})
+				failedAt = time.Now().UTC()
+				spanCtx, span := m.startMigrationSpan(ctx, migration)
+				span.SetAttributes(LogField{Key: "attempts", Value: attempt})
+				result, err := m.applyInSharedTx(spanCtx, tx, migration, LogField{Key: "single_transaction", Value: true})
+				endSpan(span, err)
+				if err != nil {
+					failed = &migration
+					return err
+				}
+				applied = append(applied, result)
+			}
+			return nil
+		})
+		outcome := HistorySucceeded
+		if err != nil {
+			outcome = HistoryRolledBack
+		}
+		for _, result := range applied {
+			duration := time.Duration(result.ExecutionTimeInMillis) * time.Millisecond
+			if err != nil {
+				m.emit(ctx, MigrationFailed{Migration: result.Migration, Attempt: attempt, Duration: duration, Err: err, RolledBack: true})
+			} else {
+				m.emit(ctx, MigrationSucceeded{Applied: result, Duration: duration})
+			}
+			m.recordAttempt(ctx, db, result.Migration, outcome, result.AppliedAt, result.ExecutionTimeInMillis, nil)
+		}
+		if failed != nil {
+			duration := time.Since(failedAt)
+			m.emit(ctx, MigrationFailed{Migration: *failed, Attempt: attempt, Duration: duration, Err: err})
+			m.recordAttempt(ctx, db, *failed, HistoryFailed, failedAt, duration.Milliseconds(), err)
+		}
+		return err
+	}, LogField{Key: "single_transaction", Value: true})
+	if err != nil {
+		if failed != nil {
+			err = m.afterEachFailure(ctx, *failed, err)
+		}
+		return nil, err
+	}
+	return applied, nil
+}
+
+// applyInSharedTx applies a single migration inside of a transaction that is
+// shared with other migrations, and inserts the record marking it as applied.
+// Any extra fields are included in the log messages about the migration.
+func (m *Migrator) applyInSharedTx(ctx context.Context, tx *sql.Tx, migration Migration, extra ...LogField) (AppliedMigration, error) {
+	startedAt := time.Now().UTC()
+	fields := append([]LogField{
+		{Key: "migration_id", Value: migration.ID},
+		{Key: "migration_checksum", Value: m.Checksum(migration)},
+		{Key: "started_at", Value: startedAt},
+	}, extra...)
+	settings := m.timeoutSettings(migration)
+	for _, setting := range settings {
+		fields = append(fields, LogField{Key: setting.name, Value: setting.value})
+	}
+	m.info(ctx, "applying migration", fields...)
+	if err := m.beforeEach(ctx, tx, migration); err != nil {
+		return AppliedMigration{}, err
+	}
+	err := m.execInSharedTx(ctx, tx, migration, settings)
+	applied, err := m.migrationResult(ctx, migration, startedAt, &fields, err)
+	if err != nil {
+		return applied, err
+	}
+	if err := m.insertAppliedMigration(ctx, tx, &applied, fields); err != nil {
+		return applied, err
+	}
+	return applied, m.afterEach(ctx, tx, applied)
+}
+
+// execInSharedTx runs a migration's SQL, or its func for Go migrations,
+// inside of a transaction that is shared with other migrations. The timeout
+// settings are restored after the migration so that they do not leak into the
+// next one.
+func (m *Migrator) execInSharedTx(ctx context.Context, tx *sql.Tx, migration Migration, settings []setting) error {
+	restore, err := m.setSessionSettings(ctx, tx, settings)
+	if err != nil {
+		return err
+	}
+	if err := m.exec(ctx, tx, migration); err != nil {
+		// The transaction is aborted and will be rolled back, so there is no
+		// need (and no way) to restore the settings.
+		return err
+	}
+	return restore()
+}
+
+// attemptMigration runs a single migration inside a transaction:
 // - BEGIN;
+// - set the lock and statement timeouts, if any
+// - call the BeforeEach hook
 // - apply the migration
 // - insert a record marking the migration as applied
+// - call the AfterEach hook
 // - COMMIT;
-func (m *Migrator) applyMigration(ctx context.Context, db Executor, migration Migration) error {
+//
+// If the migration is marked as [Migration.NoTransaction], it is instead
+// applied directly on the given connection, and the record marking it as
+// applied is only inserted after the migration has succeeded. Its first
+// *executed statements are skipped, because an earlier attempt already
+// committed them, and *executed is advanced past each statement that succeeds.
+//
+// If db is a transaction, the migration is applied inside of a savepoint, see
+// [Migrator.MigrateTx].
+func (m *Migrator) attemptMigration(ctx context.Context, db Querier, migration Migration, executed *int) (AppliedMigration, error) {
 	startedAt := time.Now().UTC()
 	fields := []LogField{
 		{Key: "migration_id", Value: migration.ID},
-		{Key: "migration_checksum", Value: migration.MD5()},
+		{Key: "migration_checksum", Value: m.Checksum(migration)},
 		{Key: "started_at", Value: startedAt},
 	}
+	settings := m.timeoutSettings(migration)
+	for _, setting := range settings {
+		fields = append(fields, LogField{Key: setting.name, Value: setting.value})
+	}
+	if migration.NoTransaction {
+		fields = append(fields, LogField{Key: "no_transaction", Value: true})
+		m.info(ctx, "applying migration without a transaction", fields...)
+		if err := m.beforeEach(ctx, nil, migration); err != nil {
+			return AppliedMigration{}, err
+		}
+		setSettings := m.setSessionSettings
+		if _, ok := m.locker().(*LeaseLocker); ok {
+			// Behind a connection pooler in transaction pooling mode, the
+			// server connection may be handed to another client as soon as
+			// the migration is done, so its settings are reset to their
+			// defaults rather than to values that may have been read from a
+			// different server connection.
+			setSettings = m.setSessionSettingsUntilReset
+		}
+		restore, err := setSettings(ctx, db, settings)
+		if err != nil {
+			return AppliedMigration{}, err
+		}
+		// Statements like `CREATE INDEX CONCURRENTLY` refuse to run as part of
+		// a multi-statement query string, because Postgres executes those in
+		// an implicit transaction, so each statement is executed by itself.
+		var query string
+		query, err = m.Render(migration)
+		if err == nil {
+			statements := pgtools.SplitStatements(query)
+			if *executed > 0 {
+				m.info(ctx, "resuming migration after the statements that were already applied",
+					append(fields, LogField{Key: "statements_applied", Value: *executed})...)
+			}
+			for _, statement := range statements[min(*executed, len(statements)):] {
+				if _, err = db.ExecContext(ctx, statement); err != nil {
+					break
+				}
+				*executed++
+			}
+		}
+		if rerr := restore(); rerr != nil {
+			err = multierr.Join(err, rerr)
+		}
+		applied, err := m.migrationResult(ctx, migration, startedAt, &fields, err)
+		if err != nil {
+			return applied, err
+		}
+		if err := m.insertAppliedMigration(ctx, db, &applied, fields); err != nil {
+			return applied, err
+		}
+		return applied, m.afterEach(ctx, nil, applied)
+	}
+	var applied AppliedMigration
+	if _, ok := db.(*sql.Tx); ok {
+		// `SET LOCAL` would last until the end of the caller's transaction,
+		// rather than the savepoint, so the migration is applied the same
+		// way as one that shares a transaction with other migrations.
+		err := m.inTx(ctx, db, func(tx *sql.Tx) (err error) {
+			applied, err = m.applyInSharedTx(ctx, tx, migration, LogField{Key: "savepoint", Value: true})
+			return err
+		})
+		return applied, err
+	}
 	m.info(ctx, "applying migration", fields...)
-	return m.inTx(ctx, db, func(tx *sql.Tx) error {
-		// Run the migration SQL
-		_, err := tx.ExecContext(ctx, migration.SQL)
-		finishedAt := time.Now().UTC()
-		executionTimeMs := finishedAt.Sub(startedAt).Milliseconds()
-		fields = append(fields,
-			LogField{Key: "execution_time_ms", Value: executionTimeMs},
-			LogField{Key: "finished_at", Value: finishedAt},
-		)
+	err := m.inTx(ctx, db, func(tx *sql.Tx) error {
+		for _, setting := range settings {
+			query := fmt.Sprintf(`SET LOCAL %s = %s`, setting.name, pgtools.Literal(setting.value))
+			if _, err := m.execContext(ctx, tx, query); err != nil {
+				return fmt.Errorf("failed to set %s: %w", setting.name, err)
+			}
+		}
+		if err := m.beforeEach(ctx, tx, migration); err != nil {
+			return err
+		}
+		err := m.exec(ctx, tx, migration)
+		applied, err = m.migrationResult(ctx, migration, startedAt, &fields, err)
 		if err != nil {
-			msg := "failed to apply migration"
-			for key, val := range pgtools.ErrorData(err) {
-				fields = append(fields, LogField{Key: key, Value: val})
+			return err
+		}
+		if err := m.insertAppliedMigration(ctx, tx, &applied, fields); err != nil {
+			return err
+		}
+		return m.afterEach(ctx, tx, applied)
+	})
+	return applied, err
+}
+
+// exec runs the migration's rendered SQL, or its func for Go migrations, in
+// the given transaction.
+func (m *Migrator) exec(ctx context.Context, tx *sql.Tx, migration Migration) error {
+	if migration.Func != nil {
+		return migration.Func(ctx, tx)
+	}
+	query, err := m.Render(migration)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query)
+	return err
+}
+
+// beforeEach calls the BeforeEach hook, if there is one.
+func (m *Migrator) beforeEach(ctx context.Context, tx *sql.Tx, migration Migration) error {
+	if m.Hooks.BeforeEach == nil {
+		return nil
+	}
+	if err := m.Hooks.BeforeEach(ctx, tx, migration); err != nil {
+		return fmt.Errorf("before each hook: %w", err)
+	}
+	return nil
+}
+
+// afterEach calls the AfterEach hook for a successfully applied migration, if
+// there is one.
+func (m *Migrator) afterEach(ctx context.Context, tx *sql.Tx, applied AppliedMigration) error {
+	if m.Hooks.AfterEach == nil {
+		return nil
+	}
+	if err := m.Hooks.AfterEach(ctx, tx, applied, nil); err != nil {
+		return &afterEachError{err: err}
+	}
+	return nil
+}
+
+// afterEachFailure calls the AfterEach hook for a migration that failed with
+// err, if there is one, and returns err along with any error from the hook.
+func (m *Migrator) afterEachFailure(ctx context.Context, migration Migration, err error) error {
+	// If the AfterEach hook itself failed, don't call it a second time.
+	var herr *afterEachError
+	if m.Hooks.AfterEach == nil || errors.As(err, &herr) {
+		return err
+	}
+	if herr := m.Hooks.AfterEach(ctx, nil, AppliedMigration{Migration: migration}, err); herr != nil {
+		err = multierr.Join(err, fmt.Errorf("after each hook: %w", herr))
+	}
+	return err
+}
+
+// afterEachError is returned when the AfterEach hook fails after a migration
+// was applied successfully.
+type afterEachError struct {
+	err error
+}
+
+func (e *afterEachError) Error() string {
+	return fmt.Sprintf("after each hook: %s", e.err)
+}
+
+func (e *afterEachError) Unwrap() error {
+	return e.err
+}
+
+// setting is a Postgres configuration parameter and the value to set it to.
+type setting struct {
+	name  string
+	value string
+}
+
+// timeoutSettings returns the `lock_timeout` and `statement_timeout` settings
+// that should be used while applying a migration, if any.
+func (m *Migrator) timeoutSettings(migration Migration) []setting {
+	lockTimeout := m.LockTimeout
+	if migration.LockTimeout != 0 {
+		lockTimeout = migration.LockTimeout
+	}
+	statementTimeout := m.StatementTimeout
+	if migration.StatementTimeout != 0 {
+		statementTimeout = migration.StatementTimeout
+	}
+	var settings []setting
+	if lockTimeout > 0 {
+		settings = append(settings, setting{"lock_timeout", timeoutValue(lockTimeout)})
+	}
+	if statementTimeout > 0 {
+		settings = append(settings, setting{"statement_timeout", timeoutValue(statementTimeout)})
+	}
+	return settings
+}
+
+// timeoutValue formats a positive timeout in milliseconds, the precision of
+// Postgres timeouts. It rounds up so that a timeout of less than 1ms does not
+// become "0ms", which Postgres treats as no timeout at all.
+func timeoutValue(timeout time.Duration) string {
+	millis := (timeout + time.Millisecond - 1) / time.Millisecond
+	return fmt.Sprintf("%dms", millis)
+}
+
+// setSessionSettingsUntilReset is like [Migrator.setSessionSettings], but the
+// returned function resets the settings to their defaults with `RESET`.
+func (m *Migrator) setSessionSettingsUntilReset(ctx context.Context, db queryer, settings []setting) (func() error, error) {
+	var set []setting
+	reset := func() error {
+		for _, setting := range set {
+			if _, err := m.execContext(ctx, db, "RESET "+setting.name); err != nil {
+				return fmt.Errorf("failed to reset %s: %w", setting.name, err)
 			}
-			m.error(ctx, err, msg, fields...)
-			return fmt.Errorf("%s: %w", msg, err)
-		}
-		m.info(ctx, "migration succeeded", fields...)
-		// Mark the migration as applied
-		applied := AppliedMigration{Migration: migration}
-		applied.Checksum = migration.MD5()
-		applied.ExecutionTimeInMillis = executionTimeMs
-		applied.AppliedAt = startedAt
-		query := fmt.Sprintf(`
-			INSERT INTO %s
-			( id, checksum, execution_time_in_millis, applied_at )
-			VALUES
-			( $1, $2, $3, $4 )`,
-			pgtools.Identifier(m.TableName),
-		)
-		m.debug(ctx, query)
-		_, err = tx.ExecContext(ctx, query, applied.ID, applied.Checksum, applied.ExecutionTimeInMillis, applied.AppliedAt)
+		}
+		return nil
+	}
+	for _, setting := range settings {
+		query := `SELECT set_config($1, $2, false)`
+		started := time.Now()
+		_, err := db.ExecContext(ctx, query, setting.name, setting.value)
+		m.debugQuery(ctx, query, started, LogField{Key: "setting", Value: setting.name}, LogField{Key: "value", Value: setting.value})
 		if err != nil {
-			msg := "failed to mark migration as applied"
-			m.error(ctx, err, msg, fields...)
-			return fmt.Errorf("%s: %w", msg, err)
+			return nil, multierr.Join(fmt.Errorf("failed to set %s: %w", setting.name, err), reset())
+		}
+		set = append(set, setting)
+	}
+	return reset, nil
+}
+
+// setSessionSettings applies settings for the rest of the session, and returns
+// a function that restores their previous values. It is used for migrations
+// that run outside of a transaction, where `SET LOCAL` has no effect, and for
+// migrations that share a transaction with other migrations.
+func (m *Migrator) setSessionSettings(ctx context.Context, db queryer, settings []setting) (func() error, error) {
+	var previous []setting
+	restore := func() error {
+		for _, prev := range previous {
+			query := `SELECT set_config($1, $2, false)`
+			started := time.Now()
+			_, err := db.ExecContext(ctx, query, prev.name, prev.value)
+			m.debugQuery(ctx, query, started, LogField{Key: "setting", Value: prev.name}, LogField{Key: "value", Value: prev.value})
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", prev.name, err)
+			}
 		}
-		m.info(ctx, "marked as applied", fields...)
 		return nil
-	})
+	}
+	for _, setting := range settings {
+		prev := setting
+		query := `SELECT current_setting($1)`
+		started := time.Now()
+		err := db.QueryRowContext(ctx, query, setting.name).Scan(&prev.value)
+		m.debugQuery(ctx, query, started, LogField{Key: "setting", Value: setting.name})
+		if err != nil {
+			return nil, multierr.Join(fmt.Errorf("failed to read %s: %w", setting.name, err), restore())
+		}
+		query = `SELECT set_config($1, $2, false)`
+		started = time.Now()
+		_, err = db.ExecContext(ctx, query, setting.name, setting.value)
+		m.debugQuery(ctx, query, started, LogField{Key: "setting", Value: setting.name}, LogField{Key: "value", Value: setting.value})
+		if err != nil {
+			return nil, multierr.Join(fmt.Errorf("failed to set %s: %w", setting.name, err), restore())
+		}
+		previous = append(previous, prev)
+	}
+	return restore, nil
+}
+
+// migrationResult logs the outcome of running a migration's SQL, and if it
+// succeeded, returns the [AppliedMigration] that should be recorded.
+func (m *Migrator) migrationResult(
+	ctx context.Context,
+	migration Migration,
+	startedAt time.Time,
+	fields *[]LogField,
+	err error,
+) (AppliedMigration, error) {
+	finishedAt := time.Now().UTC()
+	executionTimeMs := finishedAt.Sub(startedAt).Milliseconds()
+	*fields = append(*fields,
+		LogField{Key: "execution_time_ms", Value: executionTimeMs},
+		LogField{Key: "finished_at", Value: finishedAt},
+	)
+	if err != nil {
+		msg := "failed to apply migration"
+		for key, val := range pgtools.ErrorData(err) {
+			*fields = append(*fields, LogField{Key: key, Value: val})
+		}
+		m.error(ctx, err, msg, *fields...)
+		return AppliedMigration{}, fmt.Errorf("%s: %w", msg, err)
+	}
+	m.info(ctx, "migration succeeded", *fields...)
+	applied := AppliedMigration{Migration: migration}
+	applied.Checksum = m.Checksum(migration)
+	applied.ExecutionTimeInMillis = executionTimeMs
+	applied.AppliedAt = startedAt
+	return applied, nil
+}
+
+// insertAppliedMigration creates the record in the migrations table that marks
+// a migration as having been applied, and fills in the details of who applied
+// it.
+func (m *Migrator) insertAppliedMigration(
+	ctx context.Context,
+	db queryer,
+	applied *AppliedMigration,
+	fields []LogField,
+) error {
+	applied.Hostname = hostname()
+	applied.PgmigrateVersion = pgmigrateVersion()
+	if applied.Func == nil {
+		// Record a templated migration's template rather than its rendered
+		// SQL, which may contain secrets from the Vars.
+		applied.AppliedSQL = applied.Migration.SQL
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+		( id, checksum, execution_time_in_millis, applied_at,
+		  applied_by, hostname, application_name, pgmigrate_version, applied_sql )
+		VALUES
+		( $1, $2, $3, $4,
+		  current_user, $5, current_setting('application_name'), $6, $7 )
+		RETURNING applied_by, application_name`,
+		pgtools.Identifier(m.TableName),
+	)
+	err := m.queryRowContext(ctx, db, query,
+		applied.ID, applied.Checksum, applied.ExecutionTimeInMillis, applied.AppliedAt,
+		nullString(applied.Hostname), applied.PgmigrateVersion, nullString(applied.AppliedSQL),
+	).Scan(&applied.AppliedBy, &applied.ApplicationName)
+	if err != nil {
+		msg := "failed to mark migration as applied"
+		m.error(ctx, err, msg, fields...)
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	m.info(ctx, "marked as applied", fields...)
+	return nil
 }
 
 // Verify returns a list of [VerificationError]s with warnings for any migrations that:
@@ -351,6 +1347,8 @@ func (m *Migrator) applyMigration(ctx context.Context, db Executor, migration Mi
 //   - Are marked as applied in the database table but do not exist in the
 //     migrations directory.
 //   - Have a different checksum in the database than the current file hash.
+//   - Have not been applied, but sort before the latest applied migration. This
+//     is only checked if [Migrator.StrictOrdering] is set.
 //
 // These warnings usually signify that the schema described by the migrations no longer
 // matches the schema in the database. Usually the cause is removing/editing a migration
@@ -365,23 +1363,27 @@ func (m *Migrator) applyMigration(ctx context.Context, db Executor, migration Mi
 // These warnings should not prevent your application from starting, but are
 // worth showing to a human devops/db-admin/sre-type person for them to
 // investigate.
-func (m *Migrator) Verify(ctx context.Context, db Executor) ([]VerificationError, error) {
+func (m *Migrator) Verify(ctx context.Context, db Querier) ([]VerificationError, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	migrations := m.Migrations
 	applied, err := m.Applied(ctx, db)
 	if err != nil {
 		return nil, err
 	}
 
-	hashes := map[string]string{}
+	migrationsByID := map[string]Migration{}
 	for _, migration := range migrations {
-		hashes[migration.ID] = migration.MD5()
+		migrationsByID[migration.ID] = migration
 	}
 
 	var verrs []VerificationError
 	for _, appliedMigration := range applied {
-		md5, ok := hashes[appliedMigration.ID]
+		migration, ok := migrationsByID[appliedMigration.ID]
 		if !ok {
 			verrs = append(verrs, VerificationError{
+				Kind:    VerificationMissingMigration,
 				Message: "found applied migration not present on disk",
 				Fields: map[string]any{
 					"migration_id":         appliedMigration.ID,
@@ -391,14 +1393,31 @@ func (m *Migrator) Verify(ctx context.Context, db Executor) ([]VerificationError
 			})
 			continue
 		}
-		if appliedMigration.Checksum != md5 {
+		// The checksum is compared using the algorithm that produced it, which
+		// may not be the current ChecksumAlgorithm.
+		checksum := expectedChecksum(migration, appliedMigration.Checksum)
+		if appliedMigration.Checksum != checksum {
 			verrs = append(verrs, VerificationError{
+				Kind:    VerificationChecksumMismatch,
 				Message: "found applied migration with a different checksum",
 				Fields: map[string]any{
 					"migration_id":               appliedMigration.ID,
 					"migration_applied_at":       appliedMigration.AppliedAt,
 					"migration_checksum_from_db": appliedMigration.Checksum,
-					"calculated_checksum":        md5,
+					"calculated_checksum":        checksum,
+				},
+			})
+		}
+	}
+	if m.StrictOrdering {
+		latest, outOfOrder := m.findOutOfOrder(m.pending(applied), applied)
+		for _, migration := range outOfOrder {
+			verrs = append(verrs, VerificationError{
+				Kind:    VerificationOutOfOrder,
+				Message: "found unapplied migration that sorts before the latest applied migration",
+				Fields: map[string]any{
+					"migration_id":                migration.ID,
+					"latest_applied_migration_id": latest,
 				},
 			})
 		}
@@ -407,6 +1426,9 @@ func (m *Migrator) Verify(ctx context.Context, db Executor) ([]VerificationError
 }
 
 func (m *Migrator) log(ctx context.Context, level LogLevel, msg string, args ...LogField) {
+	if m.tenant != "" {
+		args = append([]LogField{{Key: "tenant", Value: m.tenant}}, args...)
+	}
 	if m.Logger != nil {
 		if hl, ok := m.Logger.(Helper); ok {
 			hl.Helper()
@@ -429,6 +1451,40 @@ func (m *Migrator) debug(ctx context.Context, msg string, args ...LogField) {
 	m.log(ctx, LogLevelDebug, msg, args...)
 }
 
+// debugQuery logs a query that has been run at debug level, along with how
+// long it took since started.
+func (m *Migrator) debugQuery(ctx context.Context, query string, started time.Time, args ...LogField) {
+	if logger, ok := m.Logger.(Helper); ok {
+		logger.Helper()
+	}
+	m.log(ctx, LogLevelDebug, query, append(args, LogField{Key: "duration", Value: time.Since(started)})...)
+}
+
+// execContext runs a statement and logs it with [Migrator.debugQuery].
+func (m *Migrator) execContext(ctx context.Context, db execer, query string, args ...any) (sql.Result, error) {
+	started := time.Now()
+	result, err := db.ExecContext(ctx, query, args...)
+	m.debugQuery(ctx, query, started)
+	return result, err
+}
+
+// queryRowContext runs a query that returns a single row and logs it with
+// [Migrator.debugQuery].
+func (m *Migrator) queryRowContext(ctx context.Context, db queryer, query string, args ...any) *sql.Row {
+	started := time.Now()
+	row := db.QueryRowContext(ctx, query, args...)
+	m.debugQuery(ctx, query, started)
+	return row
+}
+
+// queryContext runs a query and logs it with [Migrator.debugQuery].
+func (m *Migrator) queryContext(ctx context.Context, db rowsQueryer, query string, args ...any) (*sql.Rows, error) {
+	started := time.Now()
+	rows, err := db.QueryContext(ctx, query, args...)
+	m.debugQuery(ctx, query, started)
+	return rows, err
+}
+
 func (m *Migrator) error(ctx context.Context, err error, msg string, args ...LogField) {
 	args = append(args, LogField{Key: "error", Value: err})
 	if logger, ok := m.Logger.(Helper); ok {
@@ -449,16 +1505,27 @@ func scanAppliedMigrations(rows *sql.Rows) ([]AppliedMigration, error) {
 	var migrations []AppliedMigration
 	for rows.Next() {
 		migration := AppliedMigration{}
+		var appliedBy, hostname, applicationName, version, appliedSQL sql.NullString
 		err := rows.Scan(
 			&migration.ID,
 			&migration.Checksum,
 			&migration.ExecutionTimeInMillis,
 			&migration.AppliedAt,
+			&appliedBy,
+			&hostname,
+			&applicationName,
+			&version,
+			&appliedSQL,
 		)
 		if err != nil {
 			return nil, err
 		}
 		migration.AppliedAt = migration.AppliedAt.UTC()
+		migration.AppliedBy = appliedBy.String
+		migration.Hostname = hostname.String
+		migration.ApplicationName = applicationName.String
+		migration.PgmigrateVersion = version.String
+		migration.AppliedSQL = appliedSQL.String
 		migrations = append(migrations, migration)
 	}
 	return migrations, nil
@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
 )
 
 func TestLoggingSucceedsWithNilLogger(t *testing.T) {
@@ -19,3 +23,19 @@ func TestLoggingSucceedsWithNilLogger(t *testing.T) {
 	migrator.info(ctx, "hello", LogField{Key: "location", Value: "world"})
 	migrator.error(ctx, fmt.Errorf("new error"), "hello", LogField{Key: "location", Value: "world"})
 }
+
+func TestTimeoutSettingsRoundUp(t *testing.T) {
+	t.Parallel()
+	migrator := NewMigrator(nil)
+	migrator.LockTimeout = 500 * time.Microsecond
+	migrator.StatementTimeout = 1500 * time.Millisecond
+	settings := migrator.timeoutSettings(Migration{})
+	assert.Equal(t, 2, len(settings))
+	check.Equal(t, "lock_timeout", settings[0].name)
+	check.Equal(t, "1ms", settings[0].value)
+	check.Equal(t, "statement_timeout", settings[1].name)
+	check.Equal(t, "1500ms", settings[1].value)
+
+	check.Equal(t, "2ms", timeoutValue(1001*time.Microsecond))
+	check.Equal(t, "5000ms", timeoutValue(5*time.Second))
+}
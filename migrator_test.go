@@ -3,7 +3,12 @@ package pgmigrate_test
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // pgx driver
 	"github.com/peterldowns/testy/assert"
@@ -270,6 +275,7 @@ func TestVerifyMD5Mismatch(t *testing.T) {
 		check.Nil(t, err)
 		check.Equal(t, len(verrs), 1)
 		verr := verrs[0]
+		check.Equal(t, pgmigrate.VerificationChecksumMismatch, verr.Kind)
 		check.Equal(t, verr.Message, "found applied migration with a different checksum")
 		check.Equal(t, m1modified.MD5(), verr.Fields["calculated_checksum"].(string))
 		check.Equal(t, m1.MD5(), verr.Fields["migration_checksum_from_db"].(string))
@@ -306,6 +312,7 @@ func TestVerifyMissingMigration(t *testing.T) {
 		check.Nil(t, err)
 		check.Equal(t, len(verrs), 1)
 		verr := verrs[0]
+		check.Equal(t, pgmigrate.VerificationMissingMigration, verr.Kind)
 		check.Equal(t, verr.Message, "found applied migration not present on disk")
 		check.Equal(t, m1.ID, verr.Fields["migration_id"].(string))
 		check.Equal(t, m1.MD5(), verr.Fields["migration_checksum"].(string))
@@ -578,3 +585,872 @@ func TestSettingSearchPathInMigrationsDoesntBreak(t *testing.T) {
 		assert.Nil(t, err)
 	})
 }
+
+func TestApplyNoTransactionMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{
+				ID:  "0001_initial",
+				SQL: "CREATE TABLE users (email text);",
+			},
+			{
+				// Both of these statements fail if they are run inside of a
+				// transaction block.
+				ID: "0002_index",
+				SQL: `
+					CREATE INDEX CONCURRENTLY users_email_idx ON users (email);
+					VACUUM users;
+				`,
+				NoTransaction: true,
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, nil, verrs)
+
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.Equal(t, migrations[1].ID, applied[1].ID)
+		check.Equal(t, migrations[1].MD5(), applied[1].Checksum)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestFailedNoTransactionMigrationIsNotMarkedApplied(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{
+				ID:            "0001_index",
+				SQL:           "CREATE INDEX CONCURRENTLY missing_idx ON missing_table (id);",
+				NoTransaction: true,
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrationTimeoutsAreSetLocally(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{
+				// Uses the migrator's timeouts.
+				ID:  "0001_defaults",
+				SQL: "CREATE TABLE settings AS SELECT current_setting('lock_timeout') AS lock_timeout, current_setting('statement_timeout') AS statement_timeout;",
+			},
+			{
+				// Overrides the migrator's lock timeout.
+				ID:          "0002_override",
+				SQL:         "INSERT INTO settings SELECT current_setting('lock_timeout'), current_setting('statement_timeout');",
+				LockTimeout: 2 * time.Second,
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.LockTimeout = 5 * time.Second
+		migrator.StatementTimeout = time.Minute
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, nil, verrs)
+
+		rows, err := db.QueryContext(ctx, "SELECT lock_timeout, statement_timeout FROM settings")
+		assert.Nil(t, err)
+		defer rows.Close()
+		var settings [][2]string
+		for rows.Next() {
+			var lockTimeout, statementTimeout string
+			assert.Nil(t, rows.Scan(&lockTimeout, &statementTimeout))
+			settings = append(settings, [2]string{lockTimeout, statementTimeout})
+		}
+		assert.Nil(t, rows.Err())
+		check.Equal(t, [][2]string{{"5s", "1min"}, {"2s", "1min"}}, settings)
+
+		// The settings were only changed inside of each migration's transaction.
+		var lockTimeout string
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT current_setting('lock_timeout')").Scan(&lockTimeout))
+		check.Equal(t, "0", lockTimeout)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrationIsRetriedAfterLockTimeout(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "CREATE TABLE users (name text)")
+		assert.Nil(t, err)
+
+		// Hold a lock on the table that the migration needs, and release it
+		// after the first attempt has timed out.
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = tx.ExecContext(ctx, "LOCK TABLE users IN ACCESS EXCLUSIVE MODE")
+		assert.Nil(t, err)
+		go func() {
+			time.Sleep(250 * time.Millisecond)
+			_ = tx.Rollback()
+		}()
+
+		migrations := []pgmigrate.Migration{
+			{
+				ID:  "0001_add_column",
+				SQL: "ALTER TABLE users ADD COLUMN email text;",
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.LockTimeout = 50 * time.Millisecond
+		migrator.LockRetryMaxAttempts = 10
+		migrator.LockRetryBackoff = 50 * time.Millisecond
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, nil, verrs)
+
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestNoTransactionMigrationResumesAfterLockTimeout(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "CREATE TABLE users (name text); CREATE TABLE events (name text);")
+		assert.Nil(t, err)
+
+		// Hold a lock on the table that the second statement needs, and
+		// release it after the first attempt has timed out.
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = tx.ExecContext(ctx, "LOCK TABLE users IN ACCESS EXCLUSIVE MODE")
+		assert.Nil(t, err)
+		go func() {
+			time.Sleep(250 * time.Millisecond)
+			_ = tx.Rollback()
+		}()
+
+		migrations := []pgmigrate.Migration{
+			{
+				ID:            "0001_add_column",
+				SQL:           "INSERT INTO events VALUES ('adding email');\nALTER TABLE users ADD COLUMN email text;",
+				NoTransaction: true,
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.LockTimeout = 50 * time.Millisecond
+		migrator.LockRetryMaxAttempts = 10
+		migrator.LockRetryBackoff = 50 * time.Millisecond
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, nil, verrs)
+
+		// The statement that was committed before the lock timeout was not
+		// run again by the retries.
+		var count int
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT count(*) FROM events").Scan(&count))
+		check.Equal(t, 1, count)
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrationFailsAfterMaxLockRetries(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "CREATE TABLE users (name text)")
+		assert.Nil(t, err)
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		defer func() { _ = tx.Rollback() }()
+		_, err = tx.ExecContext(ctx, "LOCK TABLE users IN ACCESS EXCLUSIVE MODE")
+		assert.Nil(t, err)
+
+		migrations := []pgmigrate.Migration{
+			{
+				ID:  "0001_add_column",
+				SQL: "ALTER TABLE users ADD COLUMN email text;",
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.LockTimeout = 10 * time.Millisecond
+		migrator.LockRetryMaxAttempts = 2
+		migrator.LockRetryBackoff = 10 * time.Millisecond
+		_, err = migrator.Migrate(ctx, db)
+		check.Error(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestApplyGoMigrationsWithSQLMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{
+				ID:  "0003_constraint",
+				SQL: "ALTER TABLE users ADD CONSTRAINT name_is_upper CHECK (name = upper(name));",
+			},
+			pgmigrate.NewGoMigration("0002_uppercase_names", "uppercase-v1", func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "UPDATE users SET name = $1 WHERE name = $2", strings.ToUpper("alice"), "alice")
+				return err
+			}),
+			{
+				ID:  "0001_initial",
+				SQL: "CREATE TABLE users (name text); INSERT INTO users (name) VALUES ('alice');",
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		plan, err := migrator.Plan(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, []string{"0001_initial", "0002_uppercase_names", "0003_constraint"}, getIDs(plan))
+
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, nil, verrs)
+
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(applied))
+		check.Equal(t, "0002_uppercase_names", applied[1].ID)
+		check.Equal(t, "uppercase-v1", applied[1].Checksum)
+
+		// Changing the declared checksum of an applied Go migration results in
+		// a verification error, just like editing a SQL migration.
+		migrations[1].FuncChecksum = "uppercase-v2"
+		verrs, err = migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(verrs))
+		check.Equal(t, "found applied migration with a different checksum", verrs[0].Message)
+		check.Equal(t, "uppercase-v2", verrs[0].Fields["calculated_checksum"].(string))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestFailedGoMigrationRollsBack(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			pgmigrate.NewGoMigration("0001_fails", "fails-v1", func(ctx context.Context, tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, "CREATE TABLE users (name text)"); err != nil {
+					return err
+				}
+				return fmt.Errorf("something went wrong")
+			}),
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		var exists bool
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT to_regclass('public.users') IS NOT NULL").Scan(&exists))
+		check.False(t, exists)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestGoMigrationWithoutChecksumIsInvalid(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			pgmigrate.NewGoMigration("0001_no_checksum", "", func(context.Context, *sql.Tx) error {
+				return nil
+			}),
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		_, err := migrator.Plan(ctx, db)
+		check.Error(t, err)
+		_, err = migrator.Migrate(ctx, db)
+		check.Error(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestHooksAreCalledAroundMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text, created_by text DEFAULT current_setting('app.current_user'))"},
+			{ID: "0002_insert", SQL: "INSERT INTO users (name) VALUES ('alice')"},
+		}
+		var calls []string
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.Hooks = pgmigrate.Hooks{
+			BeforeMigrate: func(_ context.Context, plan []pgmigrate.Migration) error {
+				calls = append(calls, fmt.Sprintf("before migrate %d", len(plan)))
+				return nil
+			},
+			BeforeEach: func(ctx context.Context, tx *sql.Tx, migration pgmigrate.Migration) error {
+				calls = append(calls, "before "+migration.ID)
+				_, err := tx.ExecContext(ctx, "SELECT set_config('app.current_user', 'migrator', true)")
+				return err
+			},
+			AfterEach: func(_ context.Context, tx *sql.Tx, applied pgmigrate.AppliedMigration, err error) error {
+				check.True(t, tx != nil)
+				check.Nil(t, err)
+				calls = append(calls, "after "+applied.ID)
+				return nil
+			},
+			AfterMigrate: func(_ context.Context, applied []pgmigrate.AppliedMigration, err error) error {
+				check.Nil(t, err)
+				calls = append(calls, fmt.Sprintf("after migrate %d", len(applied)))
+				return nil
+			},
+		}
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		check.Equal(t, []string{
+			"before migrate 2",
+			"before 0001_initial",
+			"after 0001_initial",
+			"before 0002_insert",
+			"after 0002_insert",
+			"after migrate 2",
+		}, calls)
+
+		var createdBy string
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT created_by FROM users").Scan(&createdBy))
+		check.Equal(t, "migrator", createdBy)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestHookErrorAbortsMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_blocked", SQL: "CREATE TABLE blocked (name text)"},
+			{ID: "0003_never_attempted", SQL: "CREATE TABLE never (name text)"},
+		}
+		var failed []string
+		var afterMigrateErr error
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.Hooks = pgmigrate.Hooks{
+			BeforeEach: func(_ context.Context, _ *sql.Tx, migration pgmigrate.Migration) error {
+				if migration.ID == "0002_blocked" {
+					return fmt.Errorf("blocked during business hours")
+				}
+				return nil
+			},
+			AfterEach: func(_ context.Context, tx *sql.Tx, applied pgmigrate.AppliedMigration, err error) error {
+				if err != nil {
+					check.True(t, tx == nil)
+					failed = append(failed, applied.ID)
+				}
+				return nil
+			},
+			AfterMigrate: func(_ context.Context, _ []pgmigrate.AppliedMigration, err error) error {
+				afterMigrateErr = err
+				return nil
+			},
+		}
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+		check.True(t, strings.Contains(err.Error(), "blocked during business hours"))
+		check.Equal(t, []string{"0002_blocked"}, failed)
+		check.Error(t, afterMigrateErr)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 1, len(applied))
+		check.Equal(t, "0001_initial", applied[0].ID)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestBeforeMigrateHookErrorPreventsAllMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.Hooks.BeforeMigrate = func(_ context.Context, _ []pgmigrate.Migration) error {
+			return fmt.Errorf("not now")
+		}
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestSingleTransactionAppliesAllMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_slow", SQL: "SELECT pg_sleep(0.01)", StatementTimeout: time.Second},
+			{ID: "0003_insert", SQL: "INSERT INTO users (name) VALUES (current_setting('statement_timeout'))"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.SingleTransaction = true
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 3, len(applied))
+		// The timeout for one migration does not leak into the next.
+		var name string
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT name FROM users").Scan(&name))
+		check.Equal(t, "0", name)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestSingleTransactionRollsBackAllMigrationsOnFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_insert", SQL: "INSERT INTO users (name) VALUES ('alice')"},
+			{ID: "0003_invalid", SQL: "INSERT INTO missing_table (name) VALUES ('bob')"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.SingleTransaction = true
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		var exists bool
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT to_regclass('public.users') IS NOT NULL").Scan(&exists))
+		check.False(t, exists)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestSingleTransactionRefusesNoTransactionMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_index", SQL: "CREATE INDEX CONCURRENTLY users_name_idx ON users (name)", NoTransaction: true},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.SingleTransaction = true
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+		check.True(t, strings.Contains(err.Error(), "0002_index"))
+
+		// Nothing was attempted.
+		applied, err := migrator.Applied(ctx, db)
+		check.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateToAppliesMigrationsUpToTarget(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_companies", SQL: "CREATE TABLE companies (name text)"},
+			{ID: "0003_viewers", SQL: "CREATE TABLE viewers (name text)"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+
+		plan, err := migrator.PlanTo(ctx, db, "0002_companies")
+		assert.Nil(t, err)
+		check.Equal(t, migrations[:2], plan)
+
+		verrs, err := migrator.MigrateTo(ctx, db, "0002_companies")
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.Equal(t, "0001_initial", applied[0].ID)
+		check.Equal(t, "0002_companies", applied[1].ID)
+
+		// Migrating to an already-applied target is a no-op.
+		verrs, err = migrator.MigrateTo(ctx, db, "0001_initial")
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		applied, err = migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 2, len(applied))
+
+		plan, err = migrator.Plan(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, migrations[2:], plan)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateToUnknownTargetFails(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+
+		_, err := migrator.PlanTo(ctx, db, "0002_missing")
+		check.Error(t, err)
+		_, err = migrator.MigrateTo(ctx, db, "0002_missing")
+		check.Error(t, err)
+
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestStrictOrderingRejectsOutOfOrderMigrations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		initial := []pgmigrate.Migration{
+			{ID: "001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "003_create_viewers", SQL: "CREATE TABLE viewers (name text)"},
+		}
+		migrator := pgmigrate.NewMigrator(initial)
+		migrator.Logger = logger
+		migrator.StrictOrdering = true
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		// A migration from a branch that was merged "behind" the latest
+		// applied migration.
+		migrations := append([]pgmigrate.Migration{
+			{ID: "002_create_companies", SQL: "CREATE TABLE companies (name text)"},
+		}, initial...)
+		migrator = pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.StrictOrdering = true
+
+		_, err = migrator.Plan(ctx, db)
+		var ooerr *pgmigrate.OutOfOrderError
+		assert.True(t, errors.As(err, &ooerr))
+		check.Equal(t, []string{"002_create_companies"}, ooerr.IDs)
+		check.Equal(t, "003_create_viewers", ooerr.LatestApplied)
+
+		_, err = migrator.Migrate(ctx, db)
+		check.True(t, errors.As(err, &ooerr))
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 2, len(applied))
+
+		verrs, err = migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(verrs))
+		check.Equal(t, pgmigrate.VerificationOutOfOrder, verrs[0].Kind)
+		check.Equal(t, "002_create_companies", verrs[0].Fields["migration_id"].(string))
+
+		// Without strict ordering, the migration is applied normally.
+		migrator.StrictOrdering = false
+		verrs, err = migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		verrs, err = migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestAppliedMigrationsRecordWhoAppliedThem(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			pgmigrate.NewGoMigration("0002_go", "go-v1", func(_ context.Context, _ *sql.Tx) error {
+				return nil
+			}),
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		var currentUser string
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT current_user").Scan(&currentUser))
+		hostname, err := os.Hostname()
+		assert.Nil(t, err)
+
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		for _, migration := range applied {
+			check.Equal(t, currentUser, migration.AppliedBy)
+			check.Equal(t, hostname, migration.Hostname)
+			check.NotEqual(t, "", migration.PgmigrateVersion)
+		}
+		check.Equal(t, migrations[0].SQL, applied[0].AppliedSQL)
+		check.Equal(t, "", applied[1].AppliedSQL)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrationsTableIsUpgradedInPlace(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		// A migrations table created by an older version of pgmigrate.
+		_, err := db.ExecContext(ctx, `
+			CREATE TABLE public.pgmigrate_migrations (
+				id TEXT PRIMARY KEY,
+				checksum TEXT NOT NULL,
+				execution_time_in_millis BIGINT NOT NULL,
+				applied_at TIMESTAMPTZ NOT NULL
+			);
+			INSERT INTO public.pgmigrate_migrations VALUES ('0001_initial', md5('CREATE TABLE users (name text)'), 1, now());
+			COMMENT ON TABLE public.pgmigrate_migrations IS 'owned by the platform team';
+			CREATE TABLE users (name text);
+		`)
+		assert.Nil(t, err)
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text)"},
+			{ID: "0002_companies", SQL: "CREATE TABLE companies (name text)"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+
+		// Reading from the old table works before it is upgraded.
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(applied))
+		check.Equal(t, "", applied[0].AppliedBy)
+
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		applied, err = migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.Equal(t, "0001_initial", applied[0].ID)
+		check.Equal(t, "", applied[0].AppliedBy)
+		check.Equal(t, "", applied[0].AppliedSQL)
+		check.Equal(t, "0002_companies", applied[1].ID)
+		check.NotEqual(t, "", applied[1].AppliedBy)
+		check.Equal(t, migrations[1].SQL, applied[1].AppliedSQL)
+
+		// The upgrade leaves the table's comment alone, and changing the
+		// comment does not affect the recorded details.
+		var comment string
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT obj_description('public.pgmigrate_migrations'::regclass, 'pg_class')").Scan(&comment))
+		check.Equal(t, "owned by the platform team", comment)
+		_, err = db.ExecContext(ctx, "COMMENT ON TABLE public.pgmigrate_migrations IS 'something else'")
+		assert.Nil(t, err)
+		applied, err = migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.NotEqual(t, "", applied[1].AppliedBy)
+
+		// Upgrading again is a no-op.
+		verrs, err = migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestChecksumAlgorithmUpgradesOldRecords(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (\n  name text\n);\n"},
+			{ID: "0002_cats", SQL: "CREATE TABLE cats (\n  name text\n);\n"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations[:1])
+		migrator.Logger = logger
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		// Records written with MD5 still verify with a different algorithm.
+		migrator = pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.ChecksumAlgorithm = pgmigrate.ChecksumNormalized
+		verrs, err = migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+
+		// Migrating writes new records with the new algorithm, and rewrites
+		// the old records.
+		verrs, err = migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		for i, migration := range applied {
+			check.Equal(t, pgmigrate.ChecksumNormalized.Sum(migrations[i].SQL), migration.Checksum)
+		}
+
+		// Cosmetic changes don't cause verification errors, but real ones do.
+		migrator.Migrations[0].SQL = "-- the users\r\nCREATE TABLE users (\r\n    name text  \r\n);\r\n"
+		migrator.Migrations[1].SQL = "CREATE TABLE cats (\n  name text,\n  age int\n);\n"
+		verrs, err = migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(verrs))
+		check.Equal(t, pgmigrate.VerificationChecksumMismatch, verrs[0].Kind)
+		check.Equal(t, "0002_cats", verrs[0].Fields["migration_id"])
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestUnknownChecksumAlgorithmFails(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text);"},
+		})
+		migrator.Logger = logger
+		migrator.ChecksumAlgorithm = "sha1"
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTemplatedMigrationsAreRendered(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_initial", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_template", Template: true, SQL: "CREATE TABLE {{ ident .Vars.table }} (name text);"},
+		}
+
+		// A missing variable fails before any migration is applied.
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(applied))
+
+		migrator.Vars = map[string]string{"table": "cats"}
+		verrs, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		var count int
+		assert.Nil(t, db.QueryRowContext(ctx, "SELECT count(*) FROM cats").Scan(&count))
+
+		// The checksum is of the template, and the applied SQL is the
+		// template, so that the variables are never stored.
+		applied, err = migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.Equal(t, migrations[1].MD5(), applied[1].Checksum)
+		check.Equal(t, migrations[1].SQL, applied[1].AppliedSQL)
+
+		// Different variables don't cause verification errors.
+		migrator.Vars = map[string]string{"table": "dogs"}
+		verrs, err = migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		return nil
+	})
+	assert.Nil(t, err)
+}
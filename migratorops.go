@@ -3,9 +3,11 @@ package pgmigrate
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/peterldowns/pgmigrate/internal/diff"
 	"github.com/peterldowns/pgmigrate/internal/pgtools"
 )
 
@@ -19,9 +21,12 @@ import (
 // applied.
 func (m *Migrator) MarkApplied(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 	ids ...string,
 ) ([]AppliedMigration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	err := m.ensureMigrationsTable(ctx, db)
 	if err != nil {
 		return nil, err
@@ -57,29 +62,48 @@ func (m *Migrator) MarkApplied(
 			)
 		}
 	}
+	if err := m.ensureHistoryTable(ctx, db); err != nil {
+		return nil, err
+	}
 	var markedAsApplied []AppliedMigration
 	if err := m.inTx(ctx, db, func(tx *sql.Tx) error {
 		for _, migration := range toMarkApplied {
 			ma := AppliedMigration{Migration: migration}
-			ma.Checksum = migration.MD5()
+			ma.Checksum = m.Checksum(migration)
 			ma.ExecutionTimeInMillis = 0
 			ma.AppliedAt = time.Now().UTC()
 			fields := []LogField{
 				{"id", ma.ID},
 				{"checksum", ma.Checksum},
 			}
+			ma.Hostname = hostname()
+			ma.PgmigrateVersion = pgmigrateVersion()
+			// Nothing was applied, so applied_sql is left empty.
 			query := fmt.Sprintf(`
-				INSERT INTO %s ( id, checksum, execution_time_in_millis, applied_at )
-				VALUES ( $1, $2, $3, $4 )
-				ON CONFLICT DO NOTHING;`,
+				INSERT INTO %s
+				( id, checksum, execution_time_in_millis, applied_at,
+				  applied_by, hostname, application_name, pgmigrate_version )
+				VALUES
+				( $1, $2, $3, $4,
+				  current_user, $5, current_setting('application_name'), $6 )
+				ON CONFLICT DO NOTHING
+				RETURNING applied_by, application_name;`,
 				pgtools.Identifier(m.TableName),
 			)
-			_, err := tx.ExecContext(ctx, query, ma.ID, ma.Checksum, ma.ExecutionTimeInMillis, ma.AppliedAt)
-			if err != nil {
+			err := m.queryRowContext(ctx, tx, query,
+				ma.ID, ma.Checksum, ma.ExecutionTimeInMillis, ma.AppliedAt,
+				nullString(ma.Hostname), ma.PgmigrateVersion,
+			).Scan(&ma.AppliedBy, &ma.ApplicationName)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) { // no rows: already marked as applied
 				msg := "failed to mark migration as applied"
 				m.error(ctx, err, msg, fields...)
 				return fmt.Errorf("%s: %w", msg, err)
 			}
+			if err == nil {
+				if err := m.recordOp(ctx, tx, HistoryMarkApplied, ma.ID, "", ma.Checksum); err != nil {
+					return err
+				}
+			}
 			markedAsApplied = append(markedAsApplied, ma)
 		}
 		return nil
@@ -99,7 +123,7 @@ func (m *Migrator) MarkApplied(
 // applied.
 func (m *Migrator) MarkAllApplied(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 ) ([]AppliedMigration, error) {
 	var ids []string
 	for _, migration := range m.Migrations {
@@ -119,7 +143,7 @@ func (m *Migrator) MarkAllApplied(
 // unapplied.
 func (m *Migrator) MarkUnapplied(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 	ids ...string,
 ) ([]AppliedMigration, error) {
 	err := m.ensureMigrationsTable(ctx, db)
@@ -145,17 +169,28 @@ func (m *Migrator) MarkUnapplied(
 			)
 		}
 	}
+	if err := m.ensureHistoryTable(ctx, db); err != nil {
+		return nil, err
+	}
 	var removed []AppliedMigration
 	if err := m.inTx(ctx, db, func(tx *sql.Tx) error {
 		query := fmt.Sprintf(`
-			DELETE FROM %s WHERE id = any($1) RETURNING *;
-		`, pgtools.Identifier(m.TableName))
-		rows, err := tx.QueryContext(ctx, query, toRemove)
+			DELETE FROM %s WHERE id = any($1) RETURNING %s;
+		`, pgtools.Identifier(m.TableName), appliedColumns(currentMigrationsTableVersion))
+		rows, err := m.queryContext(ctx, tx, query, toRemove)
 		if err != nil {
 			return err
 		}
 		removed, err = scanAppliedMigrations(rows)
-		return err
+		if err != nil {
+			return err
+		}
+		for _, migration := range removed {
+			if err := m.recordOp(ctx, tx, HistoryMarkUnapplied, migration.ID, migration.Checksum, ""); err != nil {
+				return err
+			}
+		}
+		return nil
 	}); err != nil {
 		return nil, err
 	}
@@ -173,7 +208,7 @@ func (m *Migrator) MarkUnapplied(
 // unapplied.
 func (m *Migrator) MarkAllUnapplied(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 ) ([]AppliedMigration, error) {
 	applied, err := m.Applied(ctx, db)
 	if err != nil {
@@ -204,7 +239,7 @@ type ChecksumUpdate struct {
 // updated.
 func (m *Migrator) SetChecksums(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 	updates ...ChecksumUpdate,
 ) ([]AppliedMigration, error) {
 	err := m.ensureMigrationsTable(ctx, db)
@@ -220,6 +255,7 @@ func (m *Migrator) SetChecksums(
 		appliedMap[m.ID] = m
 	}
 	var toUpdate []AppliedMigration
+	previousChecksums := map[string]string{}
 	for _, update := range updates {
 		migrationID := update.MigrationID
 		newChecksum := update.NewChecksum
@@ -239,9 +275,13 @@ func (m *Migrator) SetChecksums(
 			)
 			continue
 		}
+		previousChecksums[migration.ID] = migration.Checksum
 		migration.Checksum = newChecksum
 		toUpdate = append(toUpdate, migration)
 	}
+	if err := m.ensureHistoryTable(ctx, db); err != nil {
+		return nil, err
+	}
 	var updated []AppliedMigration
 	if err := m.inTx(ctx, db, func(tx *sql.Tx) error {
 		for _, migration := range toUpdate {
@@ -253,13 +293,16 @@ func (m *Migrator) SetChecksums(
 				`UPDATE %s SET checksum = $1 where id = $2 and checksum != $1`,
 				pgtools.Identifier(m.TableName),
 			)
-			m.debug(ctx, query)
-			_, err := tx.ExecContext(ctx, query, migration.Checksum, migration.ID)
+			_, err := m.execContext(ctx, tx, query, migration.Checksum, migration.ID)
 			if err != nil {
 				msg := "failed to set checksum"
 				m.error(ctx, err, msg, fields...)
 				return fmt.Errorf("%s: %w", msg, err)
 			}
+			before := previousChecksums[migration.ID]
+			if err := m.recordOp(ctx, tx, HistorySetChecksum, migration.ID, before, migration.Checksum); err != nil {
+				return err
+			}
 			updated = append(updated, migration)
 		}
 		return nil
@@ -280,12 +323,15 @@ func (m *Migrator) SetChecksums(
 // recalculated.
 func (m *Migrator) RecalculateChecksums(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 	ids ...string,
 ) ([]AppliedMigration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	allChecksums := make(map[string]string, len(m.Migrations))
 	for _, migration := range m.Migrations {
-		allChecksums[migration.ID] = migration.MD5()
+		allChecksums[migration.ID] = m.Checksum(migration)
 	}
 	updates := make([]ChecksumUpdate, 0, len(ids))
 	for _, id := range ids {
@@ -303,14 +349,57 @@ func (m *Migrator) RecalculateChecksums(
 
 func (m *Migrator) RecalculateAllChecksums(
 	ctx context.Context,
-	db Executor,
+	db Querier,
 ) ([]AppliedMigration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	updates := make([]ChecksumUpdate, 0, len(m.Migrations))
 	for _, migration := range m.Migrations {
 		updates = append(updates, ChecksumUpdate{
 			MigrationID: migration.ID,
-			NewChecksum: migration.MD5(),
+			NewChecksum: m.Checksum(migration),
 		})
 	}
 	return m.SetChecksums(ctx, db, updates...)
 }
+
+// Diff returns a unified diff between the SQL that was recorded in the
+// migrations table when the migration with the given ID was applied, and the
+// migration's current SQL. It returns an empty string if they are identical.
+//
+// This is useful for understanding why [Migrator.Verify] reports a checksum
+// mismatch. It returns an error if the migration is unknown or has not been
+// applied, and if there is no recorded SQL to compare against, which is the
+// case for Go migrations, migrations marked as applied with
+// [Migrator.MarkApplied], and migrations applied by versions of pgmigrate
+// that did not record their SQL.
+func (m *Migrator) Diff(ctx context.Context, db Querier, id string) (string, error) {
+	var migration *Migration
+	for _, candidate := range m.Migrations {
+		if candidate.ID == id {
+			migration = &candidate
+			break
+		}
+	}
+	if migration == nil {
+		return "", fmt.Errorf("unknown migration %q", id)
+	}
+	if migration.Func != nil {
+		return "", fmt.Errorf("cannot diff %q: it is a Go migration", id)
+	}
+	applied, err := m.Applied(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	for _, record := range applied {
+		if record.ID != id {
+			continue
+		}
+		if record.AppliedSQL == "" {
+			return "", fmt.Errorf("cannot diff %q: no SQL was recorded when it was applied", id)
+		}
+		return diff.Unified(id+" (applied)", id+" (current)", record.AppliedSQL, migration.SQL), nil
+	}
+	return "", fmt.Errorf("cannot diff %q: it has not been applied", id)
+}
@@ -3,6 +3,7 @@ package pgmigrate_test
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"testing"
 
 	"github.com/peterldowns/testy/assert"
@@ -278,3 +279,89 @@ func TestRecalculateAllChecksums(t *testing.T) {
 	})
 	assert.Nil(t, err)
 }
+
+func TestMarkAppliedGoMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			pgmigrate.NewGoMigration("0001_go", "go-v1", func(context.Context, *sql.Tx) error {
+				return fmt.Errorf("should not be called")
+			}),
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+
+		applied, err := migrator.MarkApplied(ctx, db, "0001_go")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(applied))
+		check.Equal(t, "go-v1", applied[0].Checksum)
+
+		migrations[0].FuncChecksum = "go-v2"
+		recalculated, err := migrator.RecalculateChecksums(ctx, db, "0001_go")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(recalculated))
+		check.Equal(t, "go-v2", recalculated[0].Checksum)
+
+		verrs, err := migrator.Verify(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, nil, verrs)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrations := []pgmigrate.Migration{
+			{
+				ID:  "0001_initial",
+				SQL: "CREATE TABLE users (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY);\n",
+			},
+			{
+				ID:  "0002_marked",
+				SQL: "CREATE TABLE cats (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY);\n",
+			},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		_, err := migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+
+		// No differences
+		result, err := migrator.Diff(ctx, db, "0001_initial")
+		assert.Nil(t, err)
+		check.Equal(t, "", result)
+
+		// Edit the migration after it was applied
+		migrator.Migrations[0].SQL = "CREATE TABLE users (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, name TEXT);\n"
+		result, err = migrator.Diff(ctx, db, "0001_initial")
+		assert.Nil(t, err)
+		check.Equal(t, `--- 0001_initial (applied)
++++ 0001_initial (current)
+@@ -1 +1 @@
+-CREATE TABLE users (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY);
++CREATE TABLE users (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, name TEXT);
+`, result)
+
+		// Unknown migrations can't be diffed
+		_, err = migrator.Diff(ctx, db, "0003_unknown")
+		check.Error(t, err)
+
+		// Migrations without recorded SQL can't be diffed
+		_, err = migrator.MarkUnapplied(ctx, db, "0002_marked")
+		assert.Nil(t, err)
+		_, err = migrator.Diff(ctx, db, "0002_marked")
+		check.Error(t, err)
+		_, err = migrator.MarkApplied(ctx, db, "0002_marked")
+		assert.Nil(t, err)
+		_, err = migrator.Diff(ctx, db, "0002_marked")
+		check.Error(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,108 @@
+// Package otelmigrate traces pgmigrate's migration runs with OpenTelemetry.
+//
+//	migrator := pgmigrate.NewMigrator(migrations)
+//	migrator.Tracer = otelmigrate.NewTracer()
+//
+// It is a separate module so that pgmigrate itself does not depend on
+// OpenTelemetry.
+package otelmigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/peterldowns/pgmigrate"
+)
+
+// ScopeName is the instrumentation scope of the spans that a [Tracer] starts.
+const ScopeName = "github.com/peterldowns/pgmigrate"
+
+// AttributePrefix is added to the key of every attribute that pgmigrate sets,
+// so that "migration_id" is recorded as "pgmigrate.migration_id".
+const AttributePrefix = "pgmigrate."
+
+// Tracer is a [pgmigrate.Tracer] that starts OpenTelemetry spans.
+type Tracer struct {
+	// Provider, if set, is the tracer provider that spans are started with.
+	//
+	// [NewTracer] defaults it to nil, and spans are started with the tracer
+	// provider of the span in the caller's context, so that they are part of
+	// the caller's trace, or with the global tracer provider if there is no
+	// span in the context.
+	Provider trace.TracerProvider
+}
+
+// NewTracer returns a [Tracer] that uses the tracer provider of the span in
+// the caller's context.
+func NewTracer() Tracer {
+	return Tracer{Provider: nil}
+}
+
+// Start starts a span as a child of the span in ctx, if there is one.
+func (t Tracer) Start(ctx context.Context, name string, attrs ...pgmigrate.LogField) (context.Context, pgmigrate.Span) {
+	ctx, otelSpan := t.provider(ctx).Tracer(ScopeName).Start(ctx, name,
+		trace.WithAttributes(attributes(attrs)...),
+	)
+	return ctx, span{otelSpan}
+}
+
+func (t Tracer) provider(ctx context.Context) trace.TracerProvider {
+	if t.Provider != nil {
+		return t.Provider
+	}
+	if parent := trace.SpanFromContext(ctx); parent.SpanContext().IsValid() {
+		return parent.TracerProvider()
+	}
+	return otel.GetTracerProvider()
+}
+
+// span is a [pgmigrate.Span] that wraps an OpenTelemetry span.
+type span struct {
+	otel trace.Span
+}
+
+func (s span) SetAttributes(attrs ...pgmigrate.LogField) {
+	s.otel.SetAttributes(attributes(attrs)...)
+}
+
+// RecordError records err as an exception event on the span and marks the
+// span as failed.
+func (s span) RecordError(err error) {
+	s.otel.RecordError(err)
+	s.otel.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.otel.End()
+}
+
+// attributes converts pgmigrate's fields into OpenTelemetry attributes.
+func attributes(fields []pgmigrate.LogField) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for _, field := range fields {
+		key := AttributePrefix + field.Key
+		switch value := field.Value.(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, value))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, value))
+		case int:
+			attrs = append(attrs, attribute.Int(key, value))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, value))
+		case float64:
+			attrs = append(attrs, attribute.Float64(key, value))
+		case time.Duration:
+			attrs = append(attrs, attribute.String(key, value.String()))
+		default:
+			attrs = append(attrs, attribute.String(key, fmt.Sprint(value)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,102 @@
+package otelmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // pgx driver for postgres
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+	"github.com/peterldowns/pgmigrate/otelmigrate"
+)
+
+func newProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return provider, exporter
+}
+
+func attributeValue(span tracetest.SpanStub, key string) attribute.Value {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracerUsesProviderFromContext(t *testing.T) {
+	t.Parallel()
+	provider, exporter := newProvider()
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "startup")
+
+	tracer := otelmigrate.NewTracer()
+	_, span := tracer.Start(ctx, pgmigrate.SpanApplyMigration,
+		pgmigrate.LogField{Key: "migration_id", Value: "0001_initial"},
+	)
+	span.SetAttributes(pgmigrate.LogField{Key: "attempts", Value: 2})
+	span.End()
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 2, len(spans))
+	child := spans[0]
+	check.Equal(t, pgmigrate.SpanApplyMigration, child.Name)
+	check.Equal(t, parent.SpanContext().SpanID(), child.Parent.SpanID())
+	check.Equal(t, "0001_initial", attributeValue(child, "pgmigrate.migration_id").AsString())
+	check.Equal(t, int64(2), attributeValue(child, "pgmigrate.attempts").AsInt64())
+	check.Equal(t, codes.Unset, child.Status.Code)
+}
+
+func TestMigrateSpans(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		provider, exporter := newProvider()
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_broken", SQL: "SELECT * FROM does_not_exist;"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = pgmigrate.NewTestLogger(t)
+		migrator.Tracer = otelmigrate.Tracer{Provider: provider}
+		_, err := migrator.Migrate(ctx, db)
+		check.Error(t, err)
+
+		spans := exporter.GetSpans()
+		byName := map[string][]tracetest.SpanStub{}
+		for _, span := range spans {
+			byName[span.Name] = append(byName[span.Name], span)
+		}
+		assert.Equal(t, 1, len(byName[pgmigrate.SpanMigrate]))
+		root := byName[pgmigrate.SpanMigrate][0]
+		check.Equal(t, codes.Error, root.Status.Code)
+		check.Equal(t, 1, len(byName[pgmigrate.SpanAcquireLock]))
+		check.Equal(t, 1, len(byName[pgmigrate.SpanPlan]))
+		// Verify is not reached because a migration failed.
+		check.Equal(t, 0, len(byName[pgmigrate.SpanVerify]))
+
+		applied := byName[pgmigrate.SpanApplyMigration]
+		assert.Equal(t, 2, len(applied))
+		for _, span := range append(applied, byName[pgmigrate.SpanPlan]...) {
+			check.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID())
+		}
+		check.Equal(t, "0001_users", attributeValue(applied[0], "pgmigrate.migration_id").AsString())
+		check.Equal(t, migrator.Checksum(migrations[0]), attributeValue(applied[0], "pgmigrate.checksum").AsString())
+		check.Equal(t, codes.Unset, applied[0].Status.Code)
+		check.Equal(t, "0002_broken", attributeValue(applied[1], "pgmigrate.migration_id").AsString())
+		check.Equal(t, codes.Error, applied[1].Status.Code)
+		check.Equal(t, "42P01", attributeValue(applied[1], "pgmigrate.error_code").AsString()) // undefined_table
+		check.Equal(t, 1, len(applied[1].Events))                                              // the recorded error
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"io/fs"
+	"sort"
 	"strings"
 )
 
@@ -23,9 +24,27 @@ import (
 //	    //go:embed path/to/migrations/directory/*.sql
 //		var fs embed.FS
 //
+// Load also parses any directives from the header of each file, which is every
+// line at the start of the file that is blank or a `--` comment:
+//
+//	-- pgmigrate:no-transaction
+//	CREATE INDEX CONCURRENTLY users_email_idx ON users (email);
+//
+// The supported directives are:
+//
+//   - `-- pgmigrate:no-transaction` sets [Migration.NoTransaction]
+//   - `-- pgmigrate:lock_timeout <duration>` sets [Migration.LockTimeout]
+//   - `-- pgmigrate:statement_timeout <duration>` sets [Migration.StatementTimeout]
+//   - `-- pgmigrate:template` sets [Migration.Template]
+//
 // Load returns the migrations in sorted order.
+//
+// The ID of a migration is its filename, so files with the same name in
+// different subdirectories would have the same ID. Load returns a
+// [DuplicateIDError] listing all of them instead.
 func Load(filesystem fs.FS) ([]Migration, error) {
 	var migrations []Migration
+	paths := map[string][]string{}
 	if err := fs.WalkDir(filesystem, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -36,26 +55,65 @@ func Load(filesystem fs.FS) ([]Migration, error) {
 		migration := Migration{
 			ID: IDFromFilename(d.Name()),
 		}
+		paths[migration.ID] = append(paths[migration.ID], path)
 		data, err := fs.ReadFile(filesystem, path)
 		if err != nil {
 			return err
 		}
 		migration.SQL = string(data)
+		if err := parseDirectives(&migration); err != nil {
+			return err
+		}
 		migrations = append(migrations, migration)
 		return nil
 	}); err != nil {
 		return nil, fmt.Errorf("load: %w", err)
 	}
+	duplicates := &DuplicateIDError{Paths: map[string][]string{}}
+	for id, idPaths := range paths {
+		if len(idPaths) > 1 {
+			duplicates.Paths[id] = idPaths
+		}
+	}
+	if len(duplicates.Paths) != 0 {
+		return nil, fmt.Errorf("load: %w", duplicates)
+	}
 	SortByID(migrations)
 	return migrations, nil
 }
 
+// DuplicateIDError is returned by [Load] and [Sources.Load] when more than one
+// migration file has the same ID.
+type DuplicateIDError struct {
+	// Paths are the paths of the files that share each duplicated ID, keyed
+	// by the ID.
+	Paths map[string][]string
+}
+
+func (e *DuplicateIDError) Error() string {
+	ids := make([]string, 0, len(e.Paths))
+	for id := range e.Paths {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var parts []string
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%s (%s)", id, strings.Join(e.Paths[id], ", ")))
+	}
+	return fmt.Sprintf("found migrations with duplicate IDs: %s", strings.Join(parts, "; "))
+}
+
 // Migrate will apply any previously applied migrations. It stores metadata in the
 // [DefaultTableName] table, with the following schema:
 // - id: text not null
 // - checksum: text not null
 // - execution_time_in_millis: integer not null
 // - applied_at: timestamp with time zone not null
+// - applied_by: text, the Postgres user (current_user) that applied it
+// - hostname: text, the hostname of the machine that applied it
+// - application_name: text, the application_name of the connection
+// - pgmigrate_version: text, the version of pgmigrate that applied it
+// - applied_sql: text, the SQL that was applied, or the template of a templated migration
 //
 // It does the following things:
 //
@@ -80,6 +138,12 @@ func Load(filesystem fs.FS) ([]Migration, error) {
 // which means that future attempts to run the migrations will include it in
 // their plan.
 //
+// Migrations marked as [Migration.NoTransaction] are the exception: each of
+// their statements is run directly on the locked connection, and the record is
+// only created once all of them have succeeded. If one of these migrations
+// fails partway through, any statements that already succeeded are NOT rolled
+// back, so these migrations should be written to be safe to re-run.
+//
 // Migrate() will immediately return the error related to a failed migration,
 // and will NOT attempt to run any further migrations. Any migrations applied
 // before the failure will remain applied. Any migrations not yet applied will
@@ -100,6 +164,29 @@ func Migrate(ctx context.Context, db *sql.DB, dir fs.FS, logger Logger) ([]Verif
 	return migrator.Migrate(ctx, db)
 }
 
+// DryRun tests whether the migrations in the plan would apply successfully,
+// without leaving any trace of them in the database.
+//
+// Like [Migrate], it acquires the advisory lock and calculates a plan. Then, it
+// begins a single transaction and applies each migration in the plan inside of
+// it, recording how long each one took. Finally, the transaction is always
+// rolled back, whether or not the migrations succeeded.
+//
+// Because the migrations share one transaction, DryRun stops at the first
+// migration that fails; the migrations after it are reported as skipped, and
+// the failure is returned as an error along with the results. Migrations
+// marked as [Migration.NoTransaction] cannot be run inside of a transaction,
+// so they are always reported as skipped.
+func DryRun(ctx context.Context, db *sql.DB, dir fs.FS, logger Logger) ([]DryRunResult, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	migrator := NewMigrator(migrations)
+	migrator.Logger = logger
+	return migrator.DryRun(ctx, db)
+}
+
 // Verify returns a list of [VerificationError]s with warnings for any migrations that:
 //
 //   - Are marked as applied in the database table but do not exist in the
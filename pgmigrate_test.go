@@ -2,7 +2,10 @@ package pgmigrate_test
 
 import (
 	"embed"
+	"errors"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/peterldowns/testy/assert"
 	"github.com/peterldowns/testy/check"
@@ -60,3 +63,72 @@ func getIDs(migs []pgmigrate.Migration) []string {
 	}
 	return ids
 }
+
+func TestLoadParsesDirectives(t *testing.T) {
+	t.Parallel()
+	dir := fstest.MapFS{
+		"0001_initial.sql": {Data: []byte(`
+-- pgmigrate:lock_timeout 5s
+-- pgmigrate:statement_timeout 1m
+-- pgmigrate:template
+CREATE TABLE {{ .Vars.schema }}.users (email text);
+`)},
+		"0002_index.sql": {Data: []byte(`
+-- Adds an index without blocking writes.
+-- pgmigrate:no-transaction
+CREATE INDEX CONCURRENTLY users_email_idx ON users (email);
+-- pgmigrate:not-a-header-directive
+`)},
+	}
+	migrations, err := pgmigrate.Load(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(migrations))
+	check.False(t, migrations[0].NoTransaction)
+	check.Equal(t, 5*time.Second, migrations[0].LockTimeout)
+	check.Equal(t, time.Minute, migrations[0].StatementTimeout)
+	check.True(t, migrations[0].Template)
+	check.True(t, migrations[1].NoTransaction)
+	check.False(t, migrations[1].Template)
+	check.Equal(t, time.Duration(0), migrations[1].LockTimeout)
+}
+
+func TestLoadFailsWithUnknownDirective(t *testing.T) {
+	t.Parallel()
+	dir := fstest.MapFS{
+		"0001_initial.sql": {Data: []byte("-- pgmigrate:no-transacshun\nVACUUM;")},
+	}
+	_, err := pgmigrate.Load(dir)
+	check.Error(t, err)
+}
+
+func TestLoadFailsWithInvalidTimeoutDirective(t *testing.T) {
+	t.Parallel()
+	for _, header := range []string{
+		"-- pgmigrate:lock_timeout",
+		"-- pgmigrate:lock_timeout 5",
+		"-- pgmigrate:statement_timeout -1s",
+		"-- pgmigrate:statement_timeout 1s 2s",
+		"-- pgmigrate:lock_timeout 500us",
+	} {
+		dir := fstest.MapFS{
+			"0001_initial.sql": {Data: []byte(header + "\nSELECT 1;")},
+		}
+		_, err := pgmigrate.Load(dir)
+		check.Error(t, err)
+	}
+}
+
+func TestLoadFailsWithDuplicateIDs(t *testing.T) {
+	t.Parallel()
+	dir := fstest.MapFS{
+		"a/0001_initial.sql": {Data: []byte("SELECT 1;")},
+		"b/0001_initial.sql": {Data: []byte("SELECT 2;")},
+		"b/0002_users.sql":   {Data: []byte("SELECT 3;")},
+	}
+	_, err := pgmigrate.Load(dir)
+	var derr *pgmigrate.DuplicateIDError
+	assert.True(t, errors.As(err, &derr))
+	check.Equal(t, map[string][]string{
+		"0001_initial": {"a/0001_initial.sql", "b/0001_initial.sql"},
+	}, derr.Paths)
+}
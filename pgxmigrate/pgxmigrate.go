@@ -0,0 +1,212 @@
+// Package pgxmigrate applies pgmigrate's migrations to a [pgxpool.Pool], for
+// applications that use pgx directly instead of database/sql.
+//
+//	migrations, err := pgmigrate.Load(migrationsFS)
+//	if err != nil {
+//		return err
+//	}
+//	migrator := pgxmigrate.NewMigrator(migrations)
+//	migrator.Logger = pgmigrate.NewSlogLogger(slog.Default())
+//	verrs, err := migrator.Migrate(ctx, pool)
+//
+// Connections are acquired from the pool itself, so there is no need to open a
+// second database/sql pool just for migrations. [Migrator.Migrate] holds one
+// of the pool's connections for as long as it holds the migrations lock, and
+// applies the migrations on another, so the pool must allow at least two
+// connections (MaxConns >= 2). [Migrator.Migrate], [Migrator.MigrateTo],
+// [Migrator.MigrateTenants], and [Migrator.DryRun] return an error right away
+// for a pool that allows fewer, instead of waiting forever for a second
+// connection.
+//
+// Errors from Postgres are returned unchanged, so they can be inspected with
+// errors.As and a *pgconn.PgError to get all of their details.
+package pgxmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/peterldowns/pgmigrate"
+)
+
+// OpenDB returns a *sql.DB that acquires its connections from pool, for using
+// pgmigrate's database/sql API with a pgx pool. It does not keep any idle
+// connections of its own, and closing it does not close the pool.
+func OpenDB(pool *pgxpool.Pool) *sql.DB {
+	return stdlib.OpenDBFromPool(pool)
+}
+
+// Migrator is a [pgmigrate.Migrator] whose methods take a [pgxpool.Pool]
+// instead of a *sql.DB. Configure it through the embedded Migrator, exactly
+// like a [pgmigrate.Migrator].
+type Migrator struct {
+	*pgmigrate.Migrator
+}
+
+// NewMigrator creates a [Migrator] with the same defaults as
+// [pgmigrate.NewMigrator].
+func NewMigrator(migrations []pgmigrate.Migration) Migrator {
+	return Migrator{pgmigrate.NewMigrator(migrations)}
+}
+
+// withDB calls fn with a *sql.DB that uses pool, and closes it afterwards.
+func withDB[T any](pool *pgxpool.Pool, fn func(db *sql.DB) (T, error)) (T, error) {
+	db := OpenDB(pool)
+	defer db.Close()
+	return fn(db)
+}
+
+// withLockingDB is like withDB, for methods that hold the migrations lock on
+// one of the pool's connections while they use another. It returns an error
+// if the pool does not allow enough connections for that.
+func withLockingDB[T any](pool *pgxpool.Pool, fn func(db *sql.DB) (T, error)) (T, error) {
+	if maxConns := pool.Config().MaxConns; maxConns < 2 {
+		var zero T
+		return zero, fmt.Errorf(
+			"pool allows %d connections, but migrating needs at least 2: one to hold the migrations lock, and one to apply the migrations",
+			maxConns,
+		)
+	}
+	return withDB(pool, fn)
+}
+
+// Migrate is [pgmigrate.Migrator.Migrate] for a pool.
+func (m Migrator) Migrate(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.VerificationError, error) {
+	return withLockingDB(pool, func(db *sql.DB) ([]pgmigrate.VerificationError, error) {
+		return m.Migrator.Migrate(ctx, db)
+	})
+}
+
+// MigrateTo is [pgmigrate.Migrator.MigrateTo] for a pool.
+func (m Migrator) MigrateTo(ctx context.Context, pool *pgxpool.Pool, target string) ([]pgmigrate.VerificationError, error) {
+	return withLockingDB(pool, func(db *sql.DB) ([]pgmigrate.VerificationError, error) {
+		return m.Migrator.MigrateTo(ctx, db, target)
+	})
+}
+
+// MigrateTenants is [pgmigrate.Migrator.MigrateTenants] for a pool.
+func (m Migrator) MigrateTenants(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.TenantResult, error) {
+	return withLockingDB(pool, func(db *sql.DB) ([]pgmigrate.TenantResult, error) {
+		return m.Migrator.MigrateTenants(ctx, db)
+	})
+}
+
+// DryRun is [pgmigrate.Migrator.DryRun] for a pool.
+func (m Migrator) DryRun(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.DryRunResult, error) {
+	return withLockingDB(pool, func(db *sql.DB) ([]pgmigrate.DryRunResult, error) {
+		return m.Migrator.DryRun(ctx, db)
+	})
+}
+
+// Plan is [pgmigrate.Migrator.Plan] for a pool.
+func (m Migrator) Plan(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.Migration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.Migration, error) {
+		return m.Migrator.Plan(ctx, db)
+	})
+}
+
+// PlanTo is [pgmigrate.Migrator.PlanTo] for a pool.
+func (m Migrator) PlanTo(ctx context.Context, pool *pgxpool.Pool, target string) ([]pgmigrate.Migration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.Migration, error) {
+		return m.Migrator.PlanTo(ctx, db, target)
+	})
+}
+
+// Applied is [pgmigrate.Migrator.Applied] for a pool.
+func (m Migrator) Applied(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.Applied(ctx, db)
+	})
+}
+
+// Verify is [pgmigrate.Migrator.Verify] for a pool.
+func (m Migrator) Verify(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.VerificationError, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.VerificationError, error) {
+		return m.Migrator.Verify(ctx, db)
+	})
+}
+
+// History is [pgmigrate.Migrator.History] for a pool.
+func (m Migrator) History(ctx context.Context, pool *pgxpool.Pool, filter pgmigrate.HistoryFilter) ([]pgmigrate.HistoryEntry, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.HistoryEntry, error) {
+		return m.Migrator.History(ctx, db, filter)
+	})
+}
+
+// Diff is [pgmigrate.Migrator.Diff] for a pool.
+func (m Migrator) Diff(ctx context.Context, pool *pgxpool.Pool, id string) (string, error) {
+	return withDB(pool, func(db *sql.DB) (string, error) {
+		return m.Migrator.Diff(ctx, db, id)
+	})
+}
+
+// LockHolders is [pgmigrate.Migrator.LockHolders] for a pool.
+func (m Migrator) LockHolders(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.LockHolder, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.LockHolder, error) {
+		return m.Migrator.LockHolders(ctx, db)
+	})
+}
+
+// TerminateLockHolder is [pgmigrate.Migrator.TerminateLockHolder] for a pool.
+func (m Migrator) TerminateLockHolder(ctx context.Context, pool *pgxpool.Pool, pid int) (bool, error) {
+	return withDB(pool, func(db *sql.DB) (bool, error) {
+		return m.Migrator.TerminateLockHolder(ctx, db, pid)
+	})
+}
+
+// MarkApplied (⚠️ danger) is [pgmigrate.Migrator.MarkApplied] for a pool.
+func (m Migrator) MarkApplied(ctx context.Context, pool *pgxpool.Pool, ids ...string) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.MarkApplied(ctx, db, ids...)
+	})
+}
+
+// MarkAllApplied (⚠️ danger) is [pgmigrate.Migrator.MarkAllApplied] for a
+// pool.
+func (m Migrator) MarkAllApplied(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.MarkAllApplied(ctx, db)
+	})
+}
+
+// MarkUnapplied (⚠️ danger) is [pgmigrate.Migrator.MarkUnapplied] for a pool.
+func (m Migrator) MarkUnapplied(ctx context.Context, pool *pgxpool.Pool, ids ...string) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.MarkUnapplied(ctx, db, ids...)
+	})
+}
+
+// MarkAllUnapplied (⚠️ danger) is [pgmigrate.Migrator.MarkAllUnapplied] for a
+// pool.
+func (m Migrator) MarkAllUnapplied(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.MarkAllUnapplied(ctx, db)
+	})
+}
+
+// SetChecksums (⚠️ danger) is [pgmigrate.Migrator.SetChecksums] for a pool.
+func (m Migrator) SetChecksums(ctx context.Context, pool *pgxpool.Pool, updates ...pgmigrate.ChecksumUpdate) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.SetChecksums(ctx, db, updates...)
+	})
+}
+
+// RecalculateChecksums (⚠️ danger) is
+// [pgmigrate.Migrator.RecalculateChecksums] for a pool.
+func (m Migrator) RecalculateChecksums(ctx context.Context, pool *pgxpool.Pool, ids ...string) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.RecalculateChecksums(ctx, db, ids...)
+	})
+}
+
+// RecalculateAllChecksums (⚠️ danger) is
+// [pgmigrate.Migrator.RecalculateAllChecksums] for a pool.
+func (m Migrator) RecalculateAllChecksums(ctx context.Context, pool *pgxpool.Pool) ([]pgmigrate.AppliedMigration, error) {
+	return withDB(pool, func(db *sql.DB) ([]pgmigrate.AppliedMigration, error) {
+		return m.Migrator.RecalculateAllChecksums(ctx, db)
+	})
+}
@@ -0,0 +1,125 @@
+package pgxmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	_ "github.com/jackc/pgx/v5/stdlib" // pgx driver for postgres
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+	"github.com/peterldowns/pgmigrate/pgxmigrate"
+)
+
+// withPool is like [withdb.WithDB], but calls cb with a pgx pool connected to
+// the test database.
+func withPool(ctx context.Context, cb func(*pgxpool.Pool) error) error {
+	return withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		var dbname string
+		if err := db.QueryRowContext(ctx, "SELECT current_database()").Scan(&dbname); err != nil {
+			return err
+		}
+		pool, err := pgxpool.New(ctx, withdb.ConnectionString(dbname))
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+		return cb(pool)
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	err := withPool(ctx, func(pool *pgxpool.Pool) error {
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_posts", SQL: "CREATE TABLE posts (title text);"},
+		}
+		migrator := pgxmigrate.NewMigrator(migrations)
+		migrator.Logger = pgmigrate.NewTestLogger(t)
+
+		plan, err := migrator.Plan(ctx, pool)
+		assert.Nil(t, err)
+		check.Equal(t, 2, len(plan))
+
+		verrs, err := migrator.Migrate(ctx, pool)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(verrs))
+
+		applied, err := migrator.Applied(ctx, pool)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.Equal(t, "0001_users", applied[0].ID)
+		check.Equal(t, "0002_posts", applied[1].ID)
+
+		verrs, err = migrator.Verify(ctx, pool)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(verrs))
+
+		unapplied, err := migrator.MarkUnapplied(ctx, pool, "0002_posts")
+		assert.Nil(t, err)
+		check.Equal(t, 1, len(unapplied))
+		plan, err = migrator.Plan(ctx, pool)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan))
+		check.Equal(t, "0002_posts", plan[0].ID)
+
+		// The pool is still usable after the migrator is done with it.
+		_, err = pool.Exec(ctx, "INSERT INTO users (name) VALUES ('pgx')")
+		check.Nil(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateKeepsPgError(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	err := withPool(ctx, func(pool *pgxpool.Pool) error {
+		migrator := pgxmigrate.NewMigrator([]pgmigrate.Migration{
+			{ID: "0001_broken", SQL: "SELECT * FROM does_not_exist;"},
+		})
+		migrator.Logger = pgmigrate.NewTestLogger(t)
+		_, err := migrator.Migrate(ctx, pool)
+		assert.Error(t, err)
+
+		var pgErr *pgconn.PgError
+		assert.True(t, errors.As(err, &pgErr))
+		check.Equal(t, "42P01", pgErr.Code) // undefined_table
+		check.Equal(t, `relation "does_not_exist" does not exist`, pgErr.Message)
+		check.Equal(t, int32(15), pgErr.Position)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateRequiresTwoConnections(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	config, err := pgxpool.ParseConfig(withdb.ConnectionString("postgres"))
+	assert.Nil(t, err)
+	config.MaxConns = 1
+	// The pool does not connect until a connection is acquired, so this does
+	// not need a database.
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	assert.Nil(t, err)
+	defer pool.Close()
+
+	migrator := pgxmigrate.NewMigrator([]pgmigrate.Migration{
+		{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+	})
+	migrator.Logger = pgmigrate.NewTestLogger(t)
+	_, err = migrator.Migrate(ctx, pool)
+	assert.Error(t, err)
+	check.True(t, strings.Contains(err.Error(), "at least 2"))
+	_, err = migrator.DryRun(ctx, pool)
+	check.Error(t, err)
+}
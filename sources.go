@@ -0,0 +1,228 @@
+package pgmigrate
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Source is a named set of migrations, like the embedded migrations of one of
+// the modules that make up your application. See [Sources].
+type Source struct {
+	// Name is the namespace of the source's migrations, and is prefixed to
+	// their IDs: the migration "0003_invoices" from the source "billing" has
+	// the ID "billing/0003_invoices". It must not be empty or contain a "/".
+	Name string
+	// FS contains the source's migration files, which are loaded with [Load].
+	FS fs.FS
+	// DependsOn are the names of the sources whose migrations must be applied
+	// before this source's migrations. It is only used with [OrderBySource].
+	DependsOn []string
+}
+
+// SourceOrder is how [Sources.CompareIDs] orders the migrations of several
+// sources relative to each other.
+type SourceOrder string
+
+const (
+	// OrderByFilename orders the migrations of every source by their
+	// filenames, as if they were all in one directory, which works well when
+	// every source names its migrations with timestamps. Migrations with the
+	// same filename are ordered by the names of their sources.
+	OrderByFilename SourceOrder = "filename"
+	// OrderBySource orders the sources so that each one comes after the
+	// sources that it depends on, see [Source.DependsOn], and then orders each
+	// source's migrations by their filenames. Sources that do not depend on
+	// each other stay in the order that they are listed in.
+	OrderBySource SourceOrder = "source"
+)
+
+// Sources combines the migrations of several [Source]s so that they can be
+// applied to the same database:
+//
+//	sources := pgmigrate.Sources{
+//		Order: pgmigrate.OrderBySource,
+//		Sources: []pgmigrate.Source{
+//			{Name: "auth", FS: auth.Migrations},
+//			{Name: "billing", FS: billing.Migrations, DependsOn: []string{"auth"}},
+//		},
+//	}
+//	migrations, err := sources.Load()
+//	// handle err
+//	migrator := pgmigrate.NewMigrator(migrations)
+//	migrator.CompareIDs = sources.CompareIDs
+//
+// Because every migration's ID is prefixed with the name of its source, two
+// sources can use the same filenames without their migrations colliding.
+type Sources struct {
+	// Order is how the migrations of different sources are ordered relative
+	// to each other. The zero value is [OrderByFilename].
+	Order SourceOrder
+	// Sources are the sources to load migrations from.
+	Sources []Source
+
+	// ranks is the position of each source in [OrderBySource] order, as of
+	// the last call to [Sources.Load].
+	ranks map[string]int
+}
+
+// Load loads the migrations of every source with [Load], prefixes their IDs
+// with the names of their sources, and returns all of them sorted by
+// [Sources.CompareIDs].
+//
+// Load returns an error if the sources are invalid: if a name is used twice,
+// if a source depends on a source that does not exist, or if the sources
+// depend on each other in a cycle. If a source has more than one migration
+// with the same ID, it returns a [DuplicateIDError] that lists the duplicates
+// of every source, with the paths of the files prefixed by their source's
+// name.
+//
+// Load also works out the order of the sources for [Sources.CompareIDs], so
+// call it again after changing the sources.
+func (s *Sources) Load() ([]Migration, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	// validate has already checked for cycles.
+	s.ranks, _ = s.sourceRanks()
+	var migrations []Migration
+	duplicates := &DuplicateIDError{Paths: map[string][]string{}}
+	for _, source := range s.Sources {
+		loaded, err := Load(source.FS)
+		var derr *DuplicateIDError
+		if errors.As(err, &derr) {
+			// Report the duplicates of every source at once, with the same
+			// prefix as the IDs of the source's migrations.
+			for id, paths := range derr.Paths {
+				id = source.Name + "/" + id
+				for _, path := range paths {
+					duplicates.Paths[id] = append(duplicates.Paths[id], source.Name+"/"+path)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %w", source.Name, err)
+		}
+		for _, migration := range loaded {
+			migration.ID = source.Name + "/" + migration.ID
+			migrations = append(migrations, migration)
+		}
+	}
+	if len(duplicates.Paths) != 0 {
+		return nil, fmt.Errorf("load: %w", duplicates)
+	}
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return s.CompareIDs(migrations[i].ID, migrations[j].ID) < 0
+	})
+	return migrations, nil
+}
+
+// CompareIDs compares two migration IDs according to [Sources.Order], for use
+// as [Migrator.CompareIDs].
+//
+// With [OrderBySource], IDs that do not start with the name of one of the
+// sources, like the IDs of Go migrations that you add yourself, sort after the
+// IDs of every source. Give a Go migration an ID like "billing/0042_backfill"
+// to order it along with the migrations of the "billing" source.
+func (s *Sources) CompareIDs(a, b string) int {
+	aSource, aName := splitSourceID(a)
+	bSource, bName := splitSourceID(b)
+	if s.Order != OrderBySource {
+		return cmp.Or(strings.Compare(aName, bName), strings.Compare(aSource, bSource))
+	}
+	ranks := s.ranks
+	if ranks == nil {
+		// Load has not been called, so the order is worked out for every
+		// comparison. Invalid sources are rejected by Load, so the error is
+		// ignored here.
+		ranks, _ = s.sourceRanks()
+	}
+	rank := func(source string) int {
+		if r, ok := ranks[source]; ok {
+			return r
+		}
+		return len(s.Sources)
+	}
+	return cmp.Or(cmp.Compare(rank(aSource), rank(bSource)), strings.Compare(a, b))
+}
+
+// validate returns an error if the sources cannot be loaded or ordered.
+func (s *Sources) validate() error {
+	switch s.Order {
+	case "", OrderByFilename, OrderBySource:
+	default:
+		return fmt.Errorf("unknown source order %q", s.Order)
+	}
+	names := map[string]bool{}
+	for _, source := range s.Sources {
+		if source.Name == "" || strings.Contains(source.Name, "/") {
+			return fmt.Errorf("invalid source name %q: must be non-empty and cannot contain a \"/\"", source.Name)
+		}
+		if source.FS == nil {
+			return fmt.Errorf("source %q: missing FS", source.Name)
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate source name %q", source.Name)
+		}
+		names[source.Name] = true
+	}
+	for _, source := range s.Sources {
+		for _, dependency := range source.DependsOn {
+			if !names[dependency] {
+				return fmt.Errorf("source %q depends on unknown source %q", source.Name, dependency)
+			}
+		}
+	}
+	_, err := s.sourceRanks()
+	return err
+}
+
+// sourceRanks returns the position of each source in [OrderBySource] order, or
+// an error if the sources depend on each other in a cycle.
+func (s *Sources) sourceRanks() (map[string]int, error) {
+	ranks := map[string]int{}
+	for len(ranks) < len(s.Sources) {
+		next := -1
+		for i, source := range s.Sources {
+			if _, ranked := ranks[source.Name]; ranked {
+				continue
+			}
+			ready := true
+			for _, dependency := range source.DependsOn {
+				if _, ranked := ranks[dependency]; !ranked {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var cycle []string
+			for _, source := range s.Sources {
+				if _, ranked := ranks[source.Name]; !ranked {
+					cycle = append(cycle, source.Name)
+				}
+			}
+			return ranks, fmt.Errorf("sources have cyclic dependencies: %s", strings.Join(cycle, ", "))
+		}
+		ranks[s.Sources[next].Name] = len(ranks)
+	}
+	return ranks, nil
+}
+
+// splitSourceID splits a migration ID into the name of its source and the
+// rest of the ID. IDs without a source have an empty source name.
+func splitSourceID(id string) (string, string) {
+	source, name, found := strings.Cut(id, "/")
+	if !found {
+		return "", id
+	}
+	return source, name
+}
@@ -0,0 +1,180 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func migrationFS(filenames ...string) fstest.MapFS {
+	filesystem := fstest.MapFS{}
+	for _, filename := range filenames {
+		filesystem[filename] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	}
+	return filesystem
+}
+
+func TestSourcesOrderByFilename(t *testing.T) {
+	t.Parallel()
+	sources := pgmigrate.Sources{
+		Order: pgmigrate.OrderByFilename,
+		Sources: []pgmigrate.Source{
+			{Name: "billing", FS: migrationFS("20240102_invoices.sql", "20240301_refunds.sql")},
+			{Name: "auth", FS: migrationFS("20240101_users.sql", "20240102_invoices.sql")},
+		},
+	}
+	migrations, err := sources.Load()
+	assert.Nil(t, err)
+	check.Equal(t, []string{
+		"auth/20240101_users",
+		"auth/20240102_invoices",
+		"billing/20240102_invoices",
+		"billing/20240301_refunds",
+	}, getIDs(migrations))
+}
+
+func TestSourcesOrderBySource(t *testing.T) {
+	t.Parallel()
+	sources := pgmigrate.Sources{
+		Order: pgmigrate.OrderBySource,
+		Sources: []pgmigrate.Source{
+			{Name: "billing", FS: migrationFS("0001_invoices.sql", "0002_refunds.sql"), DependsOn: []string{"auth"}},
+			{Name: "audit", FS: migrationFS("0001_events.sql")},
+			{Name: "auth", FS: migrationFS("0001_users.sql", "0003_sessions.sql")},
+		},
+	}
+	migrations, err := sources.Load()
+	assert.Nil(t, err)
+	check.Equal(t, []string{
+		"audit/0001_events",
+		"auth/0001_users",
+		"auth/0003_sessions",
+		"billing/0001_invoices",
+		"billing/0002_refunds",
+	}, getIDs(migrations))
+
+	// IDs without a known source sort last.
+	check.True(t, sources.CompareIDs("billing/0002_refunds", "0001_go_migration") < 0)
+	check.True(t, sources.CompareIDs("auth/0003_sessions", "billing/0001_invoices") < 0)
+
+	// The order is the same without calling Load first, just slower.
+	unloaded := pgmigrate.Sources{Order: sources.Order, Sources: sources.Sources}
+	check.True(t, unloaded.CompareIDs("auth/0003_sessions", "billing/0001_invoices") < 0)
+	check.True(t, unloaded.CompareIDs("billing/0001_invoices", "audit/0001_events") > 0)
+}
+
+func TestSourcesLoadErrors(t *testing.T) {
+	t.Parallel()
+	for name, sources := range map[string]pgmigrate.Sources{
+		"unknown order": {
+			Order:   "random",
+			Sources: []pgmigrate.Source{{Name: "auth", FS: migrationFS()}},
+		},
+		"empty name": {
+			Sources: []pgmigrate.Source{{Name: "", FS: migrationFS()}},
+		},
+		"name with a slash": {
+			Sources: []pgmigrate.Source{{Name: "auth/v2", FS: migrationFS()}},
+		},
+		"missing fs": {
+			Sources: []pgmigrate.Source{{Name: "auth"}},
+		},
+		"duplicate name": {
+			Sources: []pgmigrate.Source{
+				{Name: "auth", FS: migrationFS()},
+				{Name: "auth", FS: migrationFS()},
+			},
+		},
+		"unknown dependency": {
+			Sources: []pgmigrate.Source{
+				{Name: "billing", FS: migrationFS(), DependsOn: []string{"auth"}},
+			},
+		},
+		"cyclic dependencies": {
+			Order: pgmigrate.OrderBySource,
+			Sources: []pgmigrate.Source{
+				{Name: "auth", FS: migrationFS(), DependsOn: []string{"billing"}},
+				{Name: "billing", FS: migrationFS(), DependsOn: []string{"auth"}},
+			},
+		},
+		"duplicate migration ID": {
+			Sources: []pgmigrate.Source{
+				{Name: "auth", FS: migrationFS("a/0001_users.sql", "b/0001_users.sql")},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := sources.Load()
+			check.Error(t, err)
+		})
+	}
+}
+
+func TestMigrateSources(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		sources := pgmigrate.Sources{
+			Order: pgmigrate.OrderBySource,
+			Sources: []pgmigrate.Source{
+				{Name: "billing", FS: fstest.MapFS{
+					"0001_invoices.sql": {Data: []byte("CREATE TABLE invoices (user_id int REFERENCES users (id));")},
+				}, DependsOn: []string{"auth"}},
+				{Name: "auth", FS: fstest.MapFS{
+					"0001_users.sql": {Data: []byte("CREATE TABLE users (id int PRIMARY KEY);")},
+				}},
+			},
+		}
+		migrations, err := sources.Load()
+		assert.Nil(t, err)
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.CompareIDs = sources.CompareIDs
+		migrator.StrictOrdering = true
+
+		verrs, err := migrator.MigrateTo(ctx, db, "auth/0001_users")
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(verrs))
+		plan, err := migrator.Plan(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, []string{"billing/0001_invoices"}, getIDs(plan))
+
+		verrs, err = migrator.Migrate(ctx, db)
+		assert.Nil(t, err)
+		check.Equal(t, 0, len(verrs))
+		applied, err := migrator.Applied(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(applied))
+		check.Equal(t, "auth/0001_users", applied[0].ID)
+		check.Equal(t, "billing/0001_invoices", applied[1].ID)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestSourcesLoadDuplicateIDs(t *testing.T) {
+	t.Parallel()
+	sources := pgmigrate.Sources{
+		Sources: []pgmigrate.Source{
+			{Name: "auth", FS: migrationFS("a/0001_users.sql", "b/0001_users.sql")},
+			{Name: "billing", FS: migrationFS("0001_invoices.sql", "old/0001_invoices.sql")},
+		},
+	}
+	_, err := sources.Load()
+	var derr *pgmigrate.DuplicateIDError
+	assert.True(t, errors.As(err, &derr))
+	check.Equal(t, map[string][]string{
+		"auth/0001_users":       {"auth/a/0001_users.sql", "auth/b/0001_users.sql"},
+		"billing/0001_invoices": {"billing/0001_invoices.sql", "billing/old/0001_invoices.sql"},
+	}, derr.Paths)
+}
@@ -0,0 +1,62 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+// templateData is the data that a templated migration is rendered with.
+type templateData struct {
+	// Vars are the [Migrator.Vars], available as `{{ .Vars.name }}`.
+	Vars map[string]string
+}
+
+// templateFuncs are the functions available to templated migrations, in
+// addition to the builtin functions of [text/template].
+//
+//nolint:gochecknoglobals
+var templateFuncs = template.FuncMap{
+	// `{{ ident .Vars.schema "users" }}` quotes one or more parts of an
+	// identifier, like "schema"."users".
+	"ident": pgtools.Identifier,
+	// `{{ literal .Vars.environment }}` quotes a string literal, like
+	// 'production'.
+	"literal": pgtools.Literal,
+}
+
+// Render returns the SQL that is executed when the migration is applied. For
+// a migration marked as [Migration.Template], this is the result of executing
+// its SQL as a [text/template] with the [Migrator.Vars]:
+//
+//	-- pgmigrate:template
+//	GRANT SELECT ON users TO {{ ident .Vars.app_role }};
+//
+// Any other migration's SQL is returned as-is. It is an error for a template
+// to refer to a variable that has not been set.
+//
+// The rendered SQL is never stored: the migrations table records the template
+// itself, so that any secrets in [Migrator.Vars] stay out of it.
+func (m *Migrator) Render(migration Migration) (string, error) {
+	if !migration.Template {
+		return migration.SQL, nil
+	}
+	tmpl, err := template.New(migration.ID).
+		Option("missingkey=error").
+		Funcs(templateFuncs).
+		Parse(migration.SQL)
+	if err != nil {
+		return "", fmt.Errorf("%s: parse template: %w", migration.ID, err)
+	}
+	vars := m.Vars
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, templateData{Vars: vars}); err != nil {
+		return "", fmt.Errorf("%s: render template: %w", migration.ID, err)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,41 @@
+package pgmigrate_test
+
+import (
+	"testing"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+	migrator := pgmigrate.NewMigrator(nil)
+	migrator.Vars = map[string]string{
+		"app_role": "app",
+		"schema":   "My Schema",
+		"password": "it's a secret",
+	}
+
+	// Migrations that aren't templates are returned as-is.
+	query, err := migrator.Render(pgmigrate.Migration{ID: "0001_plain", SQL: "SELECT '{{ .Vars.app_role }}';"})
+	assert.Nil(t, err)
+	check.Equal(t, "SELECT '{{ .Vars.app_role }}';", query)
+
+	query, err = migrator.Render(pgmigrate.Migration{
+		ID:       "0002_template",
+		Template: true,
+		SQL:      "GRANT USAGE ON SCHEMA {{ ident .Vars.schema }} TO {{ .Vars.app_role }};\nALTER ROLE {{ .Vars.app_role }} PASSWORD {{ literal .Vars.password }};",
+	})
+	assert.Nil(t, err)
+	check.Equal(t, "GRANT USAGE ON SCHEMA \"My Schema\" TO app;\nALTER ROLE app PASSWORD 'it''s a secret';", query)
+
+	// Missing variables and invalid templates are errors.
+	_, err = migrator.Render(pgmigrate.Migration{ID: "0003_missing", Template: true, SQL: "SELECT {{ .Vars.missing }};"})
+	check.Error(t, err)
+	_, err = pgmigrate.NewMigrator(nil).Render(pgmigrate.Migration{ID: "0004_nil_vars", Template: true, SQL: "SELECT {{ .Vars.missing }};"})
+	check.Error(t, err)
+	_, err = migrator.Render(pgmigrate.Migration{ID: "0005_invalid", Template: true, SQL: "SELECT {{ .Vars.app_role "})
+	check.Error(t, err)
+}
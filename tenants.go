@@ -0,0 +1,136 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/peterldowns/pgmigrate/internal/multierr"
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+// TenantResult is the outcome of applying the migrations to a single tenant's
+// schema with [Migrator.MigrateTenants].
+type TenantResult struct {
+	// Schema is the name of the tenant's schema.
+	Schema string
+	// Applied are the migrations that were applied to the tenant's schema. If
+	// Error is set, these were applied before the failure.
+	Applied []AppliedMigration
+	// VerificationErrors are the warnings from verifying the tenant's
+	// migrations table after the migrations were applied, see
+	// [Migrator.Verify].
+	VerificationErrors []VerificationError
+	// Error is the reason that migrating the tenant's schema failed, if it
+	// did.
+	Error error
+}
+
+// MigrateTenants applies the migrations to the schema of each tenant listed in
+// [Migrator.Tenants] or returned by [Migrator.TenantsQuery], and returns a
+// result for every tenant, in order of their schema names.
+//
+// Each tenant is migrated exactly like [Migrator.Migrate] migrates a single
+// database, but with its own migrations table, which has the same name as
+// [Migrator.TableName] but is in the tenant's schema, and its own advisory
+// lock. While a tenant is being migrated, the `search_path` of its connection
+// is set to only its schema, so the migrations should refer to objects in any
+// other schema by their qualified names.
+//
+// Up to [Migrator.TenantConcurrency] tenants are migrated at the same time,
+// each on its own connection, so the [Hooks] and the [Logger] may be called
+// concurrently. Log messages about a tenant include a "tenant" field with its
+// schema name. A tenant that fails does not stop the others from being
+// migrated; the returned error joins the errors of every tenant that failed.
+func (m *Migrator) MigrateTenants(ctx context.Context, db *sql.DB) ([]TenantResult, error) {
+	schemas, err := m.tenants(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	m.info(ctx, fmt.Sprintf("migrating %d tenants", len(schemas)))
+	concurrency := max(m.TenantConcurrency, 1)
+	results := make([]TenantResult, len(schemas))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, schema := range schemas {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = m.migrateTenant(ctx, db, schema)
+		}()
+	}
+	wg.Wait()
+	var errs []error
+	for _, result := range results {
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", result.Schema, result.Error))
+		}
+	}
+	return results, multierr.Join(errs...)
+}
+
+// migrateTenant applies the migrations to a single tenant's schema.
+func (m *Migrator) migrateTenant(ctx context.Context, db *sql.DB, schema string) TenantResult {
+	result := TenantResult{Schema: schema}
+	tenant := *m
+	tenant.tenant = schema
+	_, table := pgtools.ParseTableName(m.TableName)
+	tenant.TableName = schema + "." + table
+	if m.HistoryTableName != "" {
+		_, historyTable := pgtools.ParseTableName(m.HistoryTableName)
+		tenant.HistoryTableName = schema + "." + historyTable
+	}
+	result.Applied, result.VerificationErrors, result.Error = tenant.migrateTo(ctx, db, "")
+	return result
+}
+
+// tenants returns the sorted, de-duplicated schema names of every tenant in
+// [Migrator.Tenants] and returned by [Migrator.TenantsQuery].
+func (m *Migrator) tenants(ctx context.Context, db Executor) ([]string, error) {
+	if len(m.Tenants) == 0 && m.TenantsQuery == "" {
+		return nil, fmt.Errorf("no tenants: set Tenants or TenantsQuery")
+	}
+	seen := map[string]bool{}
+	var schemas []string
+	add := func(schema string) error {
+		if schema == "" || strings.Contains(schema, ".") {
+			return fmt.Errorf("invalid tenant schema %q", schema)
+		}
+		if !seen[schema] {
+			seen[schema] = true
+			schemas = append(schemas, schema)
+		}
+		return nil
+	}
+	for _, schema := range m.Tenants {
+		if err := add(schema); err != nil {
+			return nil, err
+		}
+	}
+	if m.TenantsQuery != "" {
+		rows, err := m.queryContext(ctx, db, m.TenantsQuery)
+		if err != nil {
+			return nil, fmt.Errorf("tenants query: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var schema string
+			if err := rows.Scan(&schema); err != nil {
+				return nil, fmt.Errorf("tenants query: %w", err)
+			}
+			if err := add(schema); err != nil {
+				return nil, err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("tenants query: %w", err)
+		}
+	}
+	sort.Strings(schemas)
+	return schemas, nil
+}
@@ -0,0 +1,81 @@
+package pgmigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/peterldowns/testy/assert"
+	"github.com/peterldowns/testy/check"
+
+	"github.com/peterldowns/pgmigrate"
+	"github.com/peterldowns/pgmigrate/internal/withdb"
+)
+
+func TestMigrateTenants(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, `
+			CREATE SCHEMA tenant_a;
+			CREATE SCHEMA tenant_b;
+			CREATE SCHEMA tenant_c;
+			-- tenant_c already has a conflicting table, so its migration fails.
+			CREATE TABLE tenant_c.users (id int);
+		`)
+		assert.Nil(t, err)
+		migrations := []pgmigrate.Migration{
+			{ID: "0001_users", SQL: "CREATE TABLE users (name text);"},
+			{ID: "0002_cats", SQL: "CREATE TABLE cats (name text);"},
+		}
+		migrator := pgmigrate.NewMigrator(migrations)
+		migrator.Logger = logger
+		migrator.Tenants = []string{"tenant_b"}
+		migrator.TenantsQuery = `SELECT nspname FROM pg_namespace WHERE nspname LIKE 'tenant_%'`
+		migrator.TenantConcurrency = 2
+		results, err := migrator.MigrateTenants(ctx, db)
+		check.Error(t, err)
+		assert.Equal(t, 3, len(results))
+
+		for _, result := range results[:2] {
+			check.Nil(t, result.Error)
+			check.Equal(t, nil, result.VerificationErrors)
+			check.Equal(t, 2, len(result.Applied))
+			// The tables are created in the tenant's schema, and the tenant's
+			// migrations table records them.
+			var count int
+			assert.Nil(t, db.QueryRowContext(ctx, `
+				SELECT count(*) FROM information_schema.tables
+				WHERE table_schema = $1 AND table_name IN ('users', 'cats', 'pgmigrate_migrations')
+			`, result.Schema).Scan(&count))
+			check.Equal(t, 3, count)
+		}
+		check.Equal(t, "tenant_a", results[0].Schema)
+		check.Equal(t, "tenant_b", results[1].Schema)
+		check.Equal(t, "tenant_c", results[2].Schema)
+		check.Error(t, results[2].Error)
+		check.Equal(t, 0, len(results[2].Applied))
+
+		// Nothing was created in the default schema.
+		var exists bool
+		assert.Nil(t, db.QueryRowContext(ctx, `SELECT to_regclass('public.users') IS NOT NULL`).Scan(&exists))
+		check.False(t, exists)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestMigrateTenantsRequiresTenants(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := pgmigrate.NewTestLogger(t)
+	err := withdb.WithDB(ctx, "pgx", func(db *sql.DB) error {
+		migrator := pgmigrate.NewMigrator(nil)
+		migrator.Logger = logger
+		_, err := migrator.MigrateTenants(ctx, db)
+		check.Error(t, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,77 @@
+package pgmigrate
+
+import (
+	"context"
+
+	"github.com/peterldowns/pgmigrate/internal/pgtools"
+)
+
+// Tracer is a generic tracing interface so that you can trace migration runs
+// with your existing tracing solution, see [Migrator.Tracer]. The
+// [github.com/peterldowns/pgmigrate/otelmigrate] package provides a Tracer
+// for OpenTelemetry.
+type Tracer interface {
+	// Start starts a span with the given name and attributes as a child of
+	// any span in ctx, and returns a context that contains the new span.
+	Start(ctx context.Context, name string, attrs ...LogField) (context.Context, Span)
+}
+
+// Span is a single operation traced by a [Tracer].
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...LogField)
+	// RecordError records that the operation failed with err.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// The names of the spans that a [Migrator] starts.
+const (
+	SpanMigrate        = "pgmigrate.Migrate"
+	SpanAcquireLock    = "pgmigrate.AcquireLock"
+	SpanPlan           = "pgmigrate.Plan"
+	SpanApplyMigration = "pgmigrate.ApplyMigration"
+	SpanVerify         = "pgmigrate.Verify"
+)
+
+// startSpan starts a span with [Migrator.Tracer], or returns a span that does
+// nothing if there is no tracer.
+func (m *Migrator) startSpan(ctx context.Context, name string, attrs ...LogField) (context.Context, Span) {
+	if m.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	if m.tenant != "" {
+		attrs = append(attrs, LogField{Key: "tenant", Value: m.tenant})
+	}
+	return m.Tracer.Start(ctx, name, attrs...)
+}
+
+// startMigrationSpan starts the span that applies a migration.
+func (m *Migrator) startMigrationSpan(ctx context.Context, migration Migration) (context.Context, Span) {
+	if m.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return m.startSpan(ctx, SpanApplyMigration,
+		LogField{Key: "migration_id", Value: migration.ID},
+		LogField{Key: "checksum", Value: m.Checksum(migration)},
+	)
+}
+
+// endSpan records err on the span, if it is not nil, along with its SQLSTATE
+// if it came from Postgres, and then ends the span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		if code := pgtools.ErrorCode(err); code != "" {
+			span.SetAttributes(LogField{Key: "error_code", Value: code})
+		}
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...LogField) {}
+func (noopSpan) RecordError(error)         {}
+func (noopSpan) End()                      {}
@@ -1,19 +1,35 @@
 package pgmigrate
 
-// A VerificationError represents a warning of either of two types:
+// A VerificationError represents a warning of one of these kinds:
 //
-//   - a migration is marked as applied to the database but is not present in
-//     the directory of migrations: this can happen if a migration is applied, but
-//     the code containing that migration is later rolled back.
-//   - a migration whose hash (when applied) doesn't match its current hash
-//     (when calculated from its SQL contents): this can happen if someone edits a
-//     migration after it was previously applied.
+//   - [VerificationMissingMigration]: a migration is marked as applied to the
+//     database but is not present in the directory of migrations: this can
+//     happen if a migration is applied, but the code containing that migration
+//     is later rolled back.
+//   - [VerificationChecksumMismatch]: a migration whose hash (when applied)
+//     doesn't match its current hash (when calculated from its SQL contents):
+//     this can happen if someone edits a migration after it was previously
+//     applied.
+//   - [VerificationOutOfOrder]: only reported when [Migrator.StrictOrdering]
+//     is set, a migration that has not been applied sorts before the latest
+//     applied migration: this can happen when two branches that each add a
+//     migration are merged in a different order than they were deployed.
 //
 // These verification errors are worth looking into, but should not be treated
 // the same as a failure to apply migrations. Typically these are warned or
 // alerted on by the app using this migration library, and results in a human
 // intervening in some way.
 type VerificationError struct {
+	Kind    VerificationErrorKind
 	Message string
 	Fields  map[string]any
 }
+
+// VerificationErrorKind identifies the kind of a [VerificationError].
+type VerificationErrorKind string
+
+const (
+	VerificationMissingMigration VerificationErrorKind = "missing_migration"
+	VerificationChecksumMismatch VerificationErrorKind = "checksum_mismatch"
+	VerificationOutOfOrder       VerificationErrorKind = "out_of_order"
+)